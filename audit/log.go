@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Results of an audited action.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Status is a commit status that treebot saw when deciding to act on a PR.
+type Status struct {
+	Context     string `json:"context"`
+	State       string `json:"state"`
+	Description string `json:"description,omitempty"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
+// Update is a dependency update in a PR that treebot acted on.
+type Update struct {
+	Name      string `json:"name"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Directory string `json:"directory,omitempty"`
+}
+
+// Entry is a single record of a mutating action that treebot took.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Actor is the GitHub user whose credentials were used for the action.
+	Actor    string   `json:"actor"`
+	Action   string   `json:"action"`
+	Repo     string   `json:"repo"`
+	Number   int      `json:"number"`
+	URL      string   `json:"url"`
+	Title    string   `json:"title"`
+	HeadSHA  string   `json:"head_sha"`
+	Statuses []Status `json:"statuses"`
+	// Updates are the dependency updates in the PR, one for each member of a
+	// grouped update.
+	Updates []Update `json:"updates,omitempty"`
+	// Rule is the policy rule that allowed the action.
+	Rule        string `json:"rule"`
+	Interactive bool   `json:"interactive"`
+	Result      string `json:"result"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Log is an append-only log of audit entries stored as JSON lines. It is safe
+// for concurrent use.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens the audit log at the given path for appending, creating it if it
+// doesn't exist.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "creating audit log directory")
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening audit log")
+	}
+	return &Log{file: f}, nil
+}
+
+// Append adds the entry to the end of the log and flushes it to disk.
+func (l *Log) Append(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encoding audit entry")
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(b); err != nil {
+		return errors.Wrap(err, "writing audit entry")
+	}
+
+	return errors.Wrap(l.file.Sync(), "syncing audit log")
+}
+
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Query filters audit entries. Zero values match all entries.
+type Query struct {
+	Repos []string
+	Since time.Time
+	Until time.Time
+}
+
+func (q Query) matches(e Entry) bool {
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !e.Time.Before(q.Until) {
+		return false
+	}
+	if len(q.Repos) == 0 {
+		return true
+	}
+	for _, r := range q.Repos {
+		if r == e.Repo {
+			return true
+		}
+	}
+	return false
+}
+
+// Read returns all the entries in the audit log at the given path that match
+// the query, in the order they were written.
+func Read(path string, q Query) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening audit log")
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, errors.Wrapf(err, "decoding audit entry on line %d", line)
+		}
+		if q.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading audit log")
+	}
+
+	return entries, nil
+}
@@ -18,6 +18,10 @@ func main() {
 	app.Commands = []*cli.Command{
 		operations.AutoAuthorize(),
 		operations.AutoMerge(),
+		operations.Combine(),
+		operations.Audit(),
+		operations.Policy(),
+		operations.Explain(),
 	}
 	app.Flags = []cli.Flag{
 		&cli.StringSliceFlag{
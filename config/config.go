@@ -0,0 +1,165 @@
+package config
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is treebot's configuration file.
+type Config struct {
+	// Freezes are the merge freeze windows that apply to every repo.
+	Freezes []FreezeWindow `yaml:"freezes"`
+	// Repos is the configuration for individual repos, keyed by the repo's
+	// full name ("owner/repo").
+	Repos map[string]RepoConfig `yaml:"repos"`
+	// Bots are custom bots whose PRs treebot can act on, in addition to the
+	// built-in ones.
+	Bots []BotConfig `yaml:"bots"`
+	// Packages are the package allow and deny rules that apply to every repo.
+	Packages PackageRules `yaml:"packages"`
+	// Policies are expression-based rules that allow or deny authorizing and
+	// merging PRs, in the order that they're evaluated.
+	Policies []PolicyConfig `yaml:"policies"`
+}
+
+// PolicyConfig is a rule that allows or denies actions on PRs whose
+// properties match an expression.
+type PolicyConfig struct {
+	Name string `yaml:"name"`
+	// Effect is "allow" or "deny".
+	Effect string `yaml:"effect"`
+	// Actions are the actions that the policy applies to ("authorize" or
+	// "merge"). If it's empty, the policy applies to both.
+	Actions []string `yaml:"actions"`
+	// When is the expression that the PR must match for the policy to apply.
+	When string `yaml:"when"`
+}
+
+// BotConfig describes a custom bot.
+type BotConfig struct {
+	// Name is used to enable the bot.
+	Name     string `yaml:"name"`
+	Username string `yaml:"username"`
+	// UserType is the type of the bot's GitHub account ("Bot" or "User"). If
+	// it's empty, any type matches.
+	UserType string `yaml:"user_type"`
+	// BranchPrefix is the prefix of the branches that the bot opens PRs from.
+	BranchPrefix string `yaml:"branch_prefix"`
+	// TitlePattern is a regular expression matching the bot's PR titles, with
+	// a capture group named "name" for the dependency name and optional
+	// capture groups named "from" and "to" for the versions.
+	TitlePattern string `yaml:"title_pattern"`
+	// MetadataPattern is a regular expression matching each dependency
+	// update in the bot's commit messages, with the same capture groups as
+	// TitlePattern and an optional capture group named "type" for the update
+	// type ("major", "minor" or "patch").
+	MetadataPattern string `yaml:"metadata_pattern"`
+	// RebaseCommand and RecreateCommand are the PR comments that ask the bot
+	// to rebase or recreate its PR. They're empty if the bot doesn't support
+	// them.
+	RebaseCommand   string `yaml:"rebase_command"`
+	RecreateCommand string `yaml:"recreate_command"`
+}
+
+// RepoConfig is the configuration for a single repo.
+type RepoConfig struct {
+	// Freezes are the merge freeze windows that apply to the repo in addition
+	// to the global ones.
+	Freezes []FreezeWindow `yaml:"freezes"`
+	// Packages are the package allow and deny rules that apply to the repo in
+	// addition to the global ones.
+	Packages PackageRules `yaml:"packages"`
+}
+
+// Load reads the YAML configuration file at the given path. If the path is
+// empty, it returns an empty configuration.
+func Load(path string) (*Config, error) {
+	var conf Config
+	if path == "" {
+		return &conf, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening config file")
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&conf); err != nil {
+		return nil, errors.Wrap(err, "decoding config file")
+	}
+
+	if err := conf.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
+	return &conf, nil
+}
+
+func (c *Config) validate() error {
+	for i := range c.Freezes {
+		if err := c.Freezes[i].init(); err != nil {
+			return errors.Wrapf(err, "freeze window #%d", i+1)
+		}
+	}
+	for name, repo := range c.Repos {
+		for i := range repo.Freezes {
+			if err := repo.Freezes[i].init(); err != nil {
+				return errors.Wrapf(err, "freeze window #%d for repo '%s'", i+1, name)
+			}
+		}
+		if err := repo.Packages.init(); err != nil {
+			return errors.Wrapf(err, "package rules for repo '%s'", name)
+		}
+		c.Repos[name] = repo
+	}
+	if err := c.Packages.init(); err != nil {
+		return errors.Wrap(err, "package rules")
+	}
+	names := map[string]bool{}
+	for i, b := range c.Bots {
+		if b.Name == "" || b.Username == "" {
+			return errors.Errorf("bot #%d must have a name and a username", i+1)
+		}
+		if names[b.Name] {
+			return errors.Errorf("bot name '%s' is used more than once", b.Name)
+		}
+		if b.RecreateCommand != "" && b.RebaseCommand == "" {
+			return errors.Errorf("bot '%s' must have a rebase command to have a recreate command", b.Name)
+		}
+		names[b.Name] = true
+	}
+	policyNames := map[string]bool{}
+	for i, p := range c.Policies {
+		if p.Name == "" || p.When == "" {
+			return errors.Errorf("policy #%d must have a name and a condition", i+1)
+		}
+		if policyNames[p.Name] {
+			return errors.Errorf("policy name '%s' is used more than once", p.Name)
+		}
+		policyNames[p.Name] = true
+	}
+	return nil
+}
+
+// ActiveFreeze returns the freeze window that applies to the repo at the given
+// time, if any, along with when that window ends.
+func (c *Config) ActiveFreeze(repo string, t time.Time) (*FreezeWindow, time.Time, bool) {
+	windows := c.Freezes
+	if rc, ok := c.Repos[repo]; ok {
+		windows = append(append([]FreezeWindow(nil), windows...), rc.Freezes...)
+	}
+
+	for i := range windows {
+		if end, ok := windows[i].ActiveAt(t); ok {
+			return &windows[i], end, true
+		}
+	}
+
+	return nil, time.Time{}, false
+}
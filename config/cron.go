@@ -0,0 +1,161 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cronSchedule is a parsed cron expression with the standard five fields:
+// minute, hour, day of month, month and day of week. Each field supports "*",
+// single values, ranges ("1-5"), lists ("1,3,5") and steps ("*/15", "0-30/5").
+type cronSchedule struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+	// anyDayOfMonth and anyDayOfWeek are set if the respective field is "*",
+	// which affects how the two day fields are combined.
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression '%s' should have 5 fields, but has %d", expr, len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, errors.Wrap(err, "parsing minute field")
+	}
+	if s.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, errors.Wrap(err, "parsing hour field")
+	}
+	if s.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, errors.Wrap(err, "parsing day of month field")
+	}
+	if s.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, errors.Wrap(err, "parsing month field")
+	}
+	// Both 0 and 7 mean Sunday.
+	if s.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, errors.Wrap(err, "parsing day of week field")
+	}
+	if s.daysOfWeek[7] {
+		s.daysOfWeek[0] = true
+	}
+	s.anyDayOfMonth = fields[2] == "*"
+	s.anyDayOfWeek = fields[4] == "*"
+
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			rng = part[:i]
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, errors.Errorf("invalid step in '%s'", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, errors.Errorf("invalid value in '%s'", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, errors.Errorf("invalid value in '%s'", part)
+				}
+			} else if step != 1 {
+				// A step with a single value (e.g. "5/10") means starting at
+				// the value.
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, errors.Errorf("'%s' is out of the range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// matches returns whether the schedule fires at the minute of the given time.
+func (s *cronSchedule) matches(t time.Time) bool {
+	return s.months[int(t.Month())] && s.matchesDay(t) && s.hours[t.Hour()] && s.minutes[t.Minute()]
+}
+
+// matchesDay returns whether the schedule fires on the day of the given time.
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	// Like standard cron, if both day fields are restricted, the schedule
+	// matches if either of them matches.
+	dom := s.daysOfMonth[t.Day()]
+	dow := s.daysOfWeek[int(t.Weekday())]
+	switch {
+	case s.anyDayOfMonth && s.anyDayOfWeek:
+		return true
+	case s.anyDayOfMonth:
+		return dow
+	case s.anyDayOfWeek:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// lastFireWithin returns the most recent time at or before t that the schedule
+// fired, if there was one within the given duration before t. Rather than
+// checking every minute, it skips back over whole months, days and hours that
+// the schedule doesn't fire in.
+func (s *cronSchedule) lastFireWithin(t time.Time, within time.Duration) (time.Time, bool) {
+	start := t.Add(-within)
+	fire := t.Truncate(time.Minute)
+	for fire.After(start) {
+		year, month, day := fire.Date()
+		loc := fire.Location()
+		switch {
+		case !s.months[int(month)]:
+			fire = minuteBefore(time.Date(year, month, 1, 0, 0, 0, 0, loc), fire)
+		case !s.matchesDay(fire):
+			fire = minuteBefore(time.Date(year, month, day, 0, 0, 0, 0, loc), fire)
+		case !s.hours[fire.Hour()]:
+			fire = minuteBefore(time.Date(year, month, day, fire.Hour(), 0, 0, 0, loc), fire)
+		case !s.minutes[fire.Minute()]:
+			fire = fire.Add(-time.Minute)
+		default:
+			return fire, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// minuteBefore returns the minute before the start of the period that t is in.
+// If a daylight saving time change makes the start ambiguous and it resolves
+// to a time after t, it returns the minute before t instead so that the search
+// never moves forward.
+func minuteBefore(periodStart, t time.Time) time.Time {
+	if periodStart.After(t) {
+		return t.Add(-time.Minute)
+	}
+	return periodStart.Add(-time.Minute)
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	for field, expected := range map[string][]int{
+		"*":       {0, 1, 2, 3, 4, 5},
+		"3":       {3},
+		"1-3":     {1, 2, 3},
+		"1,3,5":   {1, 3, 5},
+		"*/2":     {0, 2, 4},
+		"1-5/2":   {1, 3, 5},
+		"2/3":     {2, 5},
+		"0,4-5/1": {0, 4, 5},
+	} {
+		values, err := parseCronField(field, 0, 5)
+		if err != nil {
+			t.Errorf("'%s': unexpected error: %s", field, err)
+			continue
+		}
+		if len(values) != len(expected) {
+			t.Errorf("'%s': got %v, expected %v", field, values, expected)
+			continue
+		}
+		for _, v := range expected {
+			if !values[v] {
+				t.Errorf("'%s': got %v, expected %v", field, values, expected)
+				break
+			}
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("'%s': expected an error", expr)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// 2024-01-05 is a Friday and 2024-01-07 is a Sunday.
+	friday := time.Date(2024, 1, 5, 17, 30, 0, 0, time.UTC)
+	sunday := time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		expr     string
+		at       time.Time
+		expected bool
+	}{
+		{"* * * * *", friday, true},
+		{"30 17 * * *", friday, true},
+		{"31 17 * * *", friday, false},
+		{"30 17 * * 5", friday, true},
+		{"30 17 * * 1-4", friday, false},
+		{"30 17 5 * *", friday, true},
+		{"30 17 * 2 *", friday, false},
+		// If both day fields are restricted, either one can match.
+		{"30 17 1 * 5", friday, true},
+		{"30 17 5 * 1", friday, true},
+		{"30 17 1 * 1", friday, false},
+		// Both 0 and 7 mean Sunday.
+		{"0 0 * * 0", sunday, true},
+		{"0 0 * * 7", sunday, true},
+	} {
+		s, err := parseCron(tc.expr)
+		if err != nil {
+			t.Errorf("'%s': unexpected error: %s", tc.expr, err)
+			continue
+		}
+		if actual := s.matches(tc.at); actual != tc.expected {
+			t.Errorf("'%s' at %s: got %t, expected %t", tc.expr, tc.at, actual, tc.expected)
+		}
+	}
+}
+
+// lastFireByMinute is the straightforward way to find the last fire time,
+// which lastFireWithin should agree with.
+func lastFireByMinute(s *cronSchedule, t time.Time, within time.Duration) (time.Time, bool) {
+	start := t.Add(-within)
+	for fire := t.Truncate(time.Minute); fire.After(start); fire = fire.Add(-time.Minute) {
+		if s.matches(fire) {
+			return fire, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func TestLastFireWithin(t *testing.T) {
+	locs := []*time.Location{time.UTC}
+	if ny, err := time.LoadLocation("America/New_York"); err == nil {
+		locs = append(locs, ny)
+	}
+
+	exprs := []string{
+		"* * * * *",
+		"0 9 * * 1-5",
+		"*/15 2 * * *",
+		"30 1 * * *",
+		"0 0 1 * *",
+		"0 12 29 2 *",
+		"0 0 13 * 5",
+		"45 23 31 * *",
+	}
+	times := []time.Time{
+		time.Date(2024, 1, 5, 17, 30, 45, 0, time.UTC),
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		// Around the daylight saving time changes in New York.
+		time.Date(2024, 3, 10, 8, 0, 0, 0, time.UTC),
+		time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC),
+	}
+	for _, expr := range exprs {
+		s, err := parseCron(expr)
+		if err != nil {
+			t.Fatalf("'%s': unexpected error: %s", expr, err)
+		}
+		for _, loc := range locs {
+			for _, at := range times {
+				at = at.In(loc)
+				for _, within := range []time.Duration{time.Minute, 3 * time.Hour, 7 * 24 * time.Hour, 400 * 24 * time.Hour} {
+					actual, ok := s.lastFireWithin(at, within)
+					expected, expectedOK := lastFireByMinute(s, at, within)
+					if ok != expectedOK || !actual.Equal(expected) {
+						t.Errorf("'%s' at %s within %s: got (%s, %t), expected (%s, %t)", expr, at, within, actual, ok, expected, expectedOK)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxRecurringFreeze is the longest that a recurring freeze window can last.
+const maxRecurringFreeze = 31 * 24 * time.Hour
+
+// FreezeWindow is a period of time during which PRs must not be merged. It
+// is either recurring, defined by Cron and Duration, or a one-off window,
+// defined by Start and End.
+type FreezeWindow struct {
+	Name string `yaml:"name"`
+	// Timezone is the IANA time zone name (e.g. "America/New_York") that the
+	// window's times are in. Defaults to UTC.
+	Timezone string `yaml:"timezone"`
+	// Cron is a standard five-field cron expression for when a recurring
+	// window starts.
+	Cron string `yaml:"cron"`
+	// Duration is how long a recurring window lasts (e.g. "48h").
+	Duration time.Duration `yaml:"duration"`
+	// Start is when a one-off window starts, in the form "2006-01-02",
+	// "2006-01-02 15:04" or RFC3339.
+	Start string `yaml:"start"`
+	// End is when a one-off window ends, in the same form as Start. If only a
+	// date is given, the window lasts through the end of that day.
+	End string `yaml:"end"`
+
+	loc      *time.Location
+	schedule *cronSchedule
+	start    time.Time
+	end      time.Time
+}
+
+func (w *FreezeWindow) init() error {
+	loc := time.UTC
+	if w.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(w.Timezone)
+		if err != nil {
+			return errors.Wrapf(err, "loading time zone '%s'", w.Timezone)
+		}
+	}
+	w.loc = loc
+
+	recurring := w.Cron != "" || w.Duration != 0
+	oneOff := w.Start != "" || w.End != ""
+	switch {
+	case recurring && oneOff:
+		return errors.New("window cannot be both recurring (cron and duration) and one-off (start and end)")
+	case recurring:
+		if w.Cron == "" || w.Duration <= 0 {
+			return errors.New("recurring window must have both a cron expression and a positive duration")
+		}
+		if w.Duration > maxRecurringFreeze {
+			return errors.Errorf("recurring window cannot last longer than %s", maxRecurringFreeze)
+		}
+		schedule, err := parseCron(w.Cron)
+		if err != nil {
+			return errors.Wrap(err, "parsing cron expression")
+		}
+		w.schedule = schedule
+	case oneOff:
+		if w.Start == "" || w.End == "" {
+			return errors.New("one-off window must have both a start and an end")
+		}
+		var err error
+		if w.start, _, err = parseWindowTime(w.Start, loc); err != nil {
+			return errors.Wrap(err, "parsing start")
+		}
+		var isDate bool
+		if w.end, isDate, err = parseWindowTime(w.End, loc); err != nil {
+			return errors.Wrap(err, "parsing end")
+		}
+		if isDate {
+			w.end = w.end.AddDate(0, 0, 1)
+		}
+		if !w.end.After(w.start) {
+			return errors.New("end must be after start")
+		}
+	default:
+		return errors.New("window must be either recurring (cron and duration) or one-off (start and end)")
+	}
+
+	return nil
+}
+
+// ActiveAt returns whether the window is active at the given time and, if so,
+// when it ends.
+func (w *FreezeWindow) ActiveAt(t time.Time) (time.Time, bool) {
+	if w.schedule != nil {
+		start, ok := w.schedule.lastFireWithin(t.In(w.loc), w.Duration)
+		if !ok {
+			return time.Time{}, false
+		}
+		return start.Add(w.Duration), true
+	}
+
+	if !t.Before(w.start) && t.Before(w.end) {
+		return w.end, true
+	}
+	return time.Time{}, false
+}
+
+// parseWindowTime parses a date or time in the given location. It returns
+// whether the value was only a date.
+func parseWindowTime(s string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, loc); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false, errors.Errorf("'%s' is not a date (2006-01-02), local time (2006-01-02 15:04) or RFC3339 time", s)
+	}
+	return t, false, nil
+}
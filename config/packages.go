@@ -0,0 +1,90 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Package rules, from a package's perspective.
+const (
+	PackageAllowed = "allow"
+	PackageDenied  = "deny"
+)
+
+// PackageRules are glob patterns matching the names of dependencies that are
+// always or never auto-merged. In a pattern, "*" matches any sequence of
+// characters (including "/") and "?" matches any single character.
+type PackageRules struct {
+	// Allow are the packages that are always auto-merged once their checks
+	// pass, regardless of the other filters.
+	Allow []string `yaml:"allow"`
+	// Deny are the packages that are never auto-merged. Deny rules take
+	// precedence over allow rules.
+	Deny []string `yaml:"deny"`
+
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+func (r *PackageRules) init() error {
+	var err error
+	if r.allow, err = compileGlobs(r.Allow); err != nil {
+		return errors.Wrap(err, "allow rules")
+	}
+	if r.deny, err = compileGlobs(r.Deny); err != nil {
+		return errors.Wrap(err, "deny rules")
+	}
+	return nil
+}
+
+func compileGlobs(globs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(globs))
+	for _, g := range globs {
+		if strings.TrimSpace(g) == "" {
+			return nil, errors.New("package pattern must not be empty")
+		}
+		pattern := regexp.QuoteMeta(g)
+		pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+		pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+		re, err := regexp.Compile("^" + pattern + "$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "compiling package pattern '%s'", g)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// HasDenyRules returns whether any deny rules apply to the repo.
+func (c *Config) HasDenyRules(repo string) bool {
+	return len(c.Packages.Deny) != 0 || len(c.Repos[repo].Packages.Deny) != 0
+}
+
+// PackageRule returns the rule ("allow" or "deny") that applies to the package
+// in the repo, along with the pattern that matched it. The repo's rules apply
+// in addition to the global ones, and deny rules take precedence over allow
+// rules.
+func (c *Config) PackageRule(repo, name string) (string, string, bool) {
+	rules := []PackageRules{c.Packages}
+	if rc, ok := c.Repos[repo]; ok {
+		rules = append(rules, rc.Packages)
+	}
+
+	for _, r := range rules {
+		for i, re := range r.deny {
+			if re.MatchString(name) {
+				return PackageDenied, r.Deny[i], true
+			}
+		}
+	}
+	for _, r := range rules {
+		for i, re := range r.allow {
+			if re.MatchString(name) {
+				return PackageAllowed, r.Allow[i], true
+			}
+		}
+	}
+	return "", "", false
+}
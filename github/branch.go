@@ -0,0 +1,104 @@
+package github
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/go-github/v40/github"
+	"github.com/pkg/errors"
+)
+
+// ErrMergeConflict is returned when a branch can't be merged into another
+// branch because they conflict.
+var ErrMergeConflict = errors.New("branches have merge conflicts")
+
+// GetDefaultBranch gets the name of the repo's default branch.
+func (c *Client) GetDefaultBranch(ctx context.Context, owner, repo string) (string, error) {
+	r, resp, err := c.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return "", errors.Wrap(err, "getting repo")
+	}
+	defer resp.Body.Close()
+
+	return r.GetDefaultBranch(), nil
+}
+
+// GetBranchHeadSHA gets the SHA of the branch's head commit.
+func (c *Client) GetBranchHeadSHA(ctx context.Context, owner, repo, branch string) (string, error) {
+	ref, resp, err := c.Git.GetRef(ctx, owner, repo, "heads/"+branch)
+	if err != nil {
+		return "", errors.Wrapf(err, "getting branch '%s'", branch)
+	}
+	defer resp.Body.Close()
+
+	return ref.GetObject().GetSHA(), nil
+}
+
+// CreateBranch creates a branch pointing at the commit.
+func (c *Client) CreateBranch(ctx context.Context, owner, repo, branch, sha string) error {
+	_, resp, err := c.Git.CreateRef(ctx, owner, repo, &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: github.String(sha)},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "creating branch '%s'", branch)
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// ResetBranch points the branch at the commit, creating the branch if it
+// doesn't exist yet.
+func (c *Client) ResetBranch(ctx context.Context, owner, repo, branch, sha string) error {
+	_, resp, err := c.Git.GetRef(ctx, owner, repo, "heads/"+branch)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return c.CreateBranch(ctx, owner, repo, branch, sha)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "getting branch '%s'", branch)
+	}
+	resp.Body.Close()
+
+	_, resp, err = c.Git.UpdateRef(ctx, owner, repo, &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: github.String(sha)},
+	}, true)
+	if err != nil {
+		return errors.Wrapf(err, "updating branch '%s'", branch)
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// DeleteBranch deletes the branch.
+func (c *Client) DeleteBranch(ctx context.Context, owner, repo, branch string) error {
+	resp, err := c.Git.DeleteRef(ctx, owner, repo, "heads/"+branch)
+	if err != nil {
+		return errors.Wrapf(err, "deleting branch '%s'", branch)
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// MergeBranch merges the head branch or commit into the base branch. If they
+// conflict, it returns ErrMergeConflict. It returns false if the base branch
+// already contained the head.
+func (c *Client) MergeBranch(ctx context.Context, owner, repo, base, head, message string) (bool, error) {
+	_, resp, err := c.Repositories.Merge(ctx, owner, repo, &github.RepositoryMergeRequest{
+		Base:          github.String(base),
+		Head:          github.String(head),
+		CommitMessage: github.String(message),
+	})
+	if resp != nil && resp.StatusCode == http.StatusConflict {
+		return false, ErrMergeConflict
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "merging '%s' into '%s'", head, base)
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode != http.StatusNoContent, nil
+}
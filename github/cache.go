@@ -0,0 +1,250 @@
+package github
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const headerFromCache = "X-From-Cache"
+
+// cacheTransport is an http.RoundTripper that makes conditional requests using
+// the ETag and Last-Modified headers of previously cached responses. When
+// GitHub responds with 304 Not Modified, which doesn't count against the rate
+// limit, the cached response is returned instead. Cached responses are stored
+// on disk so they persist across runs. Entries that haven't been used for
+// cacheMaxAge are removed when the transport is created.
+type cacheTransport struct {
+	base http.RoundTripper
+	dir  string
+}
+
+// cacheMaxAge is how long a cached response is kept after it was last used.
+const cacheMaxAge = 7 * 24 * time.Hour
+
+// volatileQueryParams are query parameters whose values change on every run,
+// such as the time of the last notifications check. Responses to requests that
+// use them are never requested again, so caching them would only fill the
+// disk.
+var volatileQueryParams = []string{"since", "before"}
+
+func newCacheTransport(base http.RoundTripper, dir string) (*cacheTransport, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "creating cache directory")
+	}
+	t := &cacheTransport{base: base, dir: dir}
+	if err := t.prune(time.Now().Add(-cacheMaxAge)); err != nil {
+		zap.S().Debugw("could not prune HTTP cache",
+			"dir", dir,
+			"error", err,
+		)
+	}
+	return t, nil
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !cacheable(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	key := cacheKey(req)
+	entry, err := t.load(key)
+	if err != nil {
+		zap.S().Debugw("ignoring unreadable HTTP cache entry",
+			"url", req.URL.String(),
+			"error", err,
+		)
+	}
+	if entry != nil {
+		req = req.Clone(req.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		drainAndClose(resp)
+		t.touch(key)
+		return entry.response(req, resp.Header), nil
+	}
+
+	if resp.StatusCode != http.StatusOK || (resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response body")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if err := t.store(key, cacheEntry{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}); err != nil {
+		zap.S().Debugw("could not write HTTP cache entry",
+			"url", req.URL.String(),
+			"error", err,
+		)
+	}
+
+	return resp, nil
+}
+
+// cacheable returns whether the response to the request can be cached.
+func cacheable(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return false
+	}
+	query := req.URL.Query()
+	for _, param := range volatileQueryParams {
+		if _, ok := query[param]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// response builds a response from the cached entry. Headers from the 304
+// response take precedence, since they contain up-to-date information such as
+// the rate limit.
+func (e *cacheEntry) response(req *http.Request, notModifiedHeader http.Header) *http.Response {
+	header := e.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	for k, v := range notModifiedHeader {
+		header[k] = v
+	}
+	header.Set(headerFromCache, "1")
+
+	return &http.Response{
+		Status:        http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+func (t *cacheTransport) load(key string) (*cacheEntry, error) {
+	b, err := ioutil.ReadFile(filepath.Join(t.dir, key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cache entry")
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, errors.Wrap(err, "decoding cache entry")
+	}
+
+	return &entry, nil
+}
+
+func (t *cacheTransport) store(key string, entry cacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "encoding cache entry")
+	}
+
+	// Write to a temporary file first so that concurrent readers never see a
+	// partially-written entry.
+	f, err := ioutil.TempFile(t.dir, key+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary cache file")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return errors.Wrap(err, "writing temporary cache file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "closing temporary cache file")
+	}
+
+	return errors.Wrap(os.Rename(f.Name(), filepath.Join(t.dir, key)), "moving cache file into place")
+}
+
+// touch marks the cache entry as used so that it isn't pruned.
+func (t *cacheTransport) touch(key string) {
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(t.dir, key), now, now); err != nil {
+		zap.S().Debugw("could not update HTTP cache entry time",
+			"key", key,
+			"error", err,
+		)
+	}
+}
+
+// prune removes the cache entries, and any temporary files left behind by
+// interrupted writes, that were last used before the cutoff.
+func (t *cacheTransport) prune(cutoff time.Time) error {
+	files, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		return errors.Wrap(err, "listing cache directory")
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !f.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(t.dir, f.Name())); err != nil && !os.IsNotExist(err) {
+			zap.S().Debugw("could not remove expired HTTP cache entry",
+				"file", f.Name(),
+				"error", err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// cacheKey identifies a cached response. Responses depend on the credentials
+// and the requested media type as well as the URL.
+func cacheKey(req *http.Request) string {
+	h := sha256.New()
+	for _, s := range []string{
+		req.Method,
+		req.URL.String(),
+		req.Header.Get("Accept"),
+		req.Header.Get("Authorization"),
+	} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
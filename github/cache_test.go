@@ -0,0 +1,177 @@
+package github
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// etagServer serves a fixed body with an ETag and records the conditional
+// header of every request.
+type etagServer struct {
+	mu          sync.Mutex
+	ifNoneMatch []string
+}
+
+func (s *etagServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ifNoneMatch = append(s.ifNoneMatch, r.Header.Get("If-None-Match"))
+	s.mu.Unlock()
+
+	w.Header().Set("X-RateLimit-Remaining", "4999")
+	if r.Header.Get("If-None-Match") == `"v1"` {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", `"v1"`)
+	w.Header().Set("X-RateLimit-Remaining", "4998")
+	w.Write([]byte("hello"))
+}
+
+func (s *etagServer) conditionalHeaders() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ifNoneMatch...)
+}
+
+func newTestCacheTransport(t *testing.T) *cacheTransport {
+	dir, err := ioutil.TempDir("", "treebot-cache")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	transport, err := newCacheTransport(http.DefaultTransport, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return transport
+}
+
+func doCachedGet(t *testing.T, transport http.RoundTripper, url, auth string) (*http.Response, string) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return resp, string(body)
+}
+
+func TestCacheTransportRevalidates(t *testing.T) {
+	server := &etagServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	transport := newTestCacheTransport(t)
+
+	resp, body := doCachedGet(t, transport, ts.URL+"/repos/o/r", "token a")
+	if resp.StatusCode != http.StatusOK || body != "hello" {
+		t.Fatalf("got %d '%s', expected 200 'hello'", resp.StatusCode, body)
+	}
+	if resp.Header.Get(headerFromCache) != "" {
+		t.Errorf("expected the first response not to come from the cache")
+	}
+
+	resp, body = doCachedGet(t, transport, ts.URL+"/repos/o/r", "token a")
+	if resp.StatusCode != http.StatusOK || body != "hello" {
+		t.Fatalf("got %d '%s', expected the cached 200 'hello'", resp.StatusCode, body)
+	}
+	if resp.Header.Get(headerFromCache) == "" {
+		t.Errorf("expected the second response to come from the cache")
+	}
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "4999" {
+		t.Errorf("expected the headers of the 304 response to take precedence, but got remaining %s", remaining)
+	}
+
+	expected := []string{"", `"v1"`}
+	if actual := server.conditionalHeaders(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got If-None-Match headers %q, expected %q", actual, expected)
+	}
+}
+
+func TestCacheTransportSeparatesCredentials(t *testing.T) {
+	server := &etagServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	transport := newTestCacheTransport(t)
+
+	doCachedGet(t, transport, ts.URL+"/repos/o/r", "token a")
+	resp, _ := doCachedGet(t, transport, ts.URL+"/repos/o/r", "token b")
+	if resp.Header.Get(headerFromCache) != "" {
+		t.Errorf("expected a response cached for other credentials not to be used")
+	}
+
+	expected := []string{"", ""}
+	if actual := server.conditionalHeaders(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got If-None-Match headers %q, expected %q", actual, expected)
+	}
+}
+
+func TestCacheTransportSkipsUncacheableRequests(t *testing.T) {
+	server := &etagServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	for name, url := range map[string]string{
+		"VolatileQuery": ts.URL + "/notifications?since=2024-01-01T00:00:00Z",
+		"Before":        ts.URL + "/notifications?before=2024-01-01T00:00:00Z",
+	} {
+		transport := newTestCacheTransport(t)
+		doCachedGet(t, transport, url, "token a")
+		resp, _ := doCachedGet(t, transport, url, "token a")
+		if resp.Header.Get(headerFromCache) != "" {
+			t.Errorf("%s: expected the response not to be cached", name)
+		}
+		files, err := ioutil.ReadDir(transport.dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(files) != 0 {
+			t.Errorf("%s: expected no cache entries, but found %d", name, len(files))
+		}
+	}
+}
+
+func TestCacheTransportPrune(t *testing.T) {
+	transport := newTestCacheTransport(t)
+	now := time.Now()
+
+	for name, age := range map[string]time.Duration{
+		"fresh":   time.Hour,
+		"expired": cacheMaxAge + time.Hour,
+	} {
+		path := filepath.Join(transport.dir, name)
+		if err := ioutil.WriteFile(path, []byte("{}"), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if err := transport.prune(now.Add(-cacheMaxAge)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(transport.dir, "fresh")); err != nil {
+		t.Errorf("expected the fresh entry to be kept: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(transport.dir, "expired")); !os.IsNotExist(err) {
+		t.Errorf("expected the expired entry to be removed")
+	}
+}
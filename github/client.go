@@ -2,8 +2,11 @@ package github
 
 import (
 	"context"
+	"net/http"
+	"sync"
 
 	"github.com/google/go-github/v40/github"
+	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
 )
 
@@ -11,12 +14,51 @@ type AuthenticationOptions struct {
 	Token string
 }
 
+type ClientOptions struct {
+	// CacheDir is the directory where GitHub API responses are cached for
+	// conditional requests. If empty, responses are not cached.
+	CacheDir  string
+	RateLimit RateLimitOptions
+}
+
 type Client struct {
 	*github.Client
+	rateLimiter *rateLimitTransport
+
+	// prs memoizes the PRs fetched during this run by their API URL.
+	prs   map[string]github.PullRequest
+	prsMu sync.Mutex
 }
 
-func NewClient(ctx context.Context, token string) *Client {
+func NewClient(ctx context.Context, token string, opts ClientOptions) (*Client, error) {
+	rateLimiter := newRateLimitTransport(http.DefaultTransport, opts.RateLimit)
+	var transport http.RoundTripper = rateLimiter
+	if opts.CacheDir != "" {
+		cache, err := newCacheTransport(rateLimiter, opts.CacheDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "setting up HTTP cache")
+		}
+		transport = cache
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+
 	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 	tc := oauth2.NewClient(ctx, ts)
-	return &Client{github.NewClient(tc)}
+	return &Client{
+		Client:      github.NewClient(tc),
+		rateLimiter: rateLimiter,
+		prs:         map[string]github.PullRequest{},
+	}, nil
+}
+
+// GetAuthenticatedUser gets the login of the user whose credentials the client
+// is using.
+func (c *Client) GetAuthenticatedUser(ctx context.Context) (string, error) {
+	u, resp, err := c.Users.Get(ctx, "")
+	if err != nil {
+		return "", errors.Wrap(err, "getting authenticated user")
+	}
+	defer resp.Body.Close()
+
+	return u.GetLogin(), nil
 }
@@ -0,0 +1,394 @@
+package github
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Types of version updates, from the smallest to the largest.
+const (
+	UpdateTypePatch = "patch"
+	UpdateTypeMinor = "minor"
+	UpdateTypeMajor = "major"
+)
+
+// UpdateTypes returns the types of version updates, from the smallest to the
+// largest.
+func UpdateTypes() []string {
+	return []string{UpdateTypePatch, UpdateTypeMinor, UpdateTypeMajor}
+}
+
+// CompareUpdateTypes returns a negative number if a is a smaller update type
+// than b, a positive number if it's larger and zero if they're the same.
+// Unknown update types are larger than all known ones.
+func CompareUpdateTypes(a, b string) int {
+	rank := func(t string) int {
+		for i, known := range UpdateTypes() {
+			if t == known {
+				return i
+			}
+		}
+		return len(UpdateTypes())
+	}
+	return rank(a) - rank(b)
+}
+
+// UpdateType returns the type of the version update. If the bot didn't report
+// it, it's based on which part of a semantic version changed. It returns an
+// empty string if the versions can't be compared.
+func (u DependencyUpdate) UpdateType() string {
+	if u.Type != "" {
+		return u.Type
+	}
+	from, ok := parseVersion(u.From)
+	if !ok {
+		return ""
+	}
+	to, ok := parseVersion(u.To)
+	if !ok {
+		return ""
+	}
+	switch {
+	case from[0] != to[0]:
+		return UpdateTypeMajor
+	case from[1] != to[1]:
+		return UpdateTypeMinor
+	default:
+		return UpdateTypePatch
+	}
+}
+
+// MaxUpdateType returns the largest type of version update among the updates.
+// It returns false if there are no updates or the type of any of them is
+// unknown.
+func MaxUpdateType(updates []DependencyUpdate) (string, bool) {
+	var max string
+	for _, u := range updates {
+		t := u.UpdateType()
+		if t == "" {
+			return "", false
+		}
+		if max == "" || CompareUpdateTypes(t, max) > 0 {
+			max = t
+		}
+	}
+	return max, max != ""
+}
+
+// MergeDependencyUpdates combines the dependency updates parsed from different
+// sources, such as the PR title, description and commit metadata, into one
+// update per dependency and directory, since a grouped update can update the
+// same dependency in several directories. Fields that are missing from an
+// earlier source are filled in from later ones. An update whose directory is
+// unknown fills in every update of the same dependency, and an update whose
+// directory is known fills in an update of the same dependency whose
+// directory is unknown.
+func MergeDependencyUpdates(sources ...[]DependencyUpdate) []DependencyUpdate {
+	var merged []DependencyUpdate
+	for _, updates := range sources {
+		for _, u := range updates {
+			var matched bool
+			for i := range merged {
+				m := &merged[i]
+				if m.Name != u.Name || (m.Directory != u.Directory && m.Directory != "" && u.Directory != "") {
+					continue
+				}
+				matched = true
+				fillUpdate(m, u)
+				if u.Directory != "" {
+					break
+				}
+			}
+			if !matched {
+				merged = append(merged, u)
+			}
+		}
+	}
+	return merged
+}
+
+// fillUpdate fills in the fields of the update that are missing from the
+// other update.
+func fillUpdate(u *DependencyUpdate, other DependencyUpdate) {
+	if u.From == "" {
+		u.From = other.From
+	}
+	if u.To == "" {
+		u.To = other.To
+	}
+	if u.Directory == "" {
+		u.Directory = other.Directory
+	}
+	if u.Type == "" {
+		u.Type = other.Type
+	}
+}
+
+// versionRegexp matches a semver-like version, such as "1.2.3", "v1.2",
+// "2.0.0-rc.1" or "1.2.3.4", optionally after a range operator like "~>" or
+// "^". Anything else, such as a commit SHA, isn't a version.
+var versionRegexp = regexp.MustCompile(`^(?:[~^=<>!]+\s*)?v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:\.\d+)*(?:[-+][0-9A-Za-z.-]+)?$`)
+
+// parseVersion parses the major, minor and patch numbers of a semver-like
+// version. Missing numbers are zero.
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	m := versionRegexp.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return parsed, false
+	}
+	for i := range parsed {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
+
+// DependabotTitle is the parsed title of a Dependabot PR.
+type DependabotTitle struct {
+	// Updates are the dependency updates named in the title. Grouped updates
+	// don't name their dependencies in the title.
+	Updates []DependencyUpdate
+	// Directory is the directory of the updated manifest, if the title names
+	// one.
+	Directory string
+	// Group is the name of the dependency group, if the PR is a grouped
+	// update.
+	Group string
+	// UpdateCount is the number of updates in a grouped update.
+	UpdateCount int
+	// DirectoryCount is the number of directories that a grouped update
+	// spans, if it spans more than one.
+	DirectoryCount int
+}
+
+// dependabotTitlePrefix matches the optional conventional commit prefix that
+// Dependabot can be configured to add to titles, such as "build(deps): ", and
+// the "[Security]" marker that it adds to security updates, which can come
+// before or after the conventional commit prefix.
+const dependabotTitlePrefix = `(?i)^(?:\[security\]\s*)?(?:[\w-]+(?:\([^)]*\))?!?:\s*)?(?:\[security\]\s*)?`
+
+// dependabotTitleDirectory matches the optional directory at the end of a
+// title.
+const dependabotTitleDirectory = `(?:\s+in\s+(?P<dir>/\S*))?\s*$`
+
+var (
+	dependabotGroupTitleRegexp       = regexp.MustCompile(dependabotTitlePrefix + `bump the (?P<group>\S+) group(?: across (?P<dirs>\d+) directories)?(?: in (?P<dir>/\S*))? with (?P<count>\d+) updates?\s*$`)
+	dependabotBumpTitleRegexp        = regexp.MustCompile(dependabotTitlePrefix + `bump (?P<name>\S+) from (?P<from>\S+) to (?P<to>\S+)` + dependabotTitleDirectory)
+	dependabotRequirementTitleRegexp = regexp.MustCompile(dependabotTitlePrefix + `update (?P<name>\S+) requirement from (?P<from>.+?) to (?P<to>.+?)` + dependabotTitleDirectory)
+	dependabotMultiTitleRegexp       = regexp.MustCompile(dependabotTitlePrefix + `bump (?P<names>\S+(?:, \S+)* and \S+)` + dependabotTitleDirectory)
+)
+
+// ParseDependabotTitle parses the common forms of Dependabot PR titles:
+//
+//	Bump lodash from 4.17.20 to 4.17.21
+//	Bump lodash from 4.17.20 to 4.17.21 in /web
+//	[Security] Bump lodash from 4.17.20 to 4.17.21
+//	build(deps): bump lodash from 4.17.20 to 4.17.21
+//	Update rake requirement from ~> 12.3 to ~> 13.0
+//	Bump @babel/core and @babel/preset-env in /web
+//	Bump the go group with 3 updates
+//	Bump the aws group across 3 directories with 7 updates
+//
+// It returns false if the title doesn't match any of them.
+func ParseDependabotTitle(title string) (DependabotTitle, bool) {
+	title = strings.TrimSpace(title)
+
+	if g, ok := matchGroups(dependabotGroupTitleRegexp, title); ok {
+		t := DependabotTitle{
+			Group:     g["group"],
+			Directory: g["dir"],
+		}
+		t.UpdateCount, _ = strconv.Atoi(g["count"])
+		t.DirectoryCount, _ = strconv.Atoi(g["dirs"])
+		return t, true
+	}
+
+	for _, re := range []*regexp.Regexp{dependabotBumpTitleRegexp, dependabotRequirementTitleRegexp} {
+		if g, ok := matchGroups(re, title); ok {
+			return DependabotTitle{
+				Updates: []DependencyUpdate{{
+					Name:      g["name"],
+					From:      g["from"],
+					To:        g["to"],
+					Directory: g["dir"],
+				}},
+				Directory: g["dir"],
+			}, true
+		}
+	}
+
+	if g, ok := matchGroups(dependabotMultiTitleRegexp, title); ok {
+		t := DependabotTitle{Directory: g["dir"]}
+		names := strings.Replace(g["names"], " and ", ", ", 1)
+		for _, name := range strings.Split(names, ", ") {
+			t.Updates = append(t.Updates, DependencyUpdate{Name: name, Directory: g["dir"]})
+		}
+		return t, true
+	}
+
+	return DependabotTitle{}, false
+}
+
+// matchGroups matches the string against the regexp and returns the values of
+// its named capture groups.
+func matchGroups(re *regexp.Regexp, s string) (map[string]string, bool) {
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	groups := map[string]string{}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			groups[name] = m[i]
+		}
+	}
+	return groups, true
+}
+
+func parseDependabotTitle(title string) []DependencyUpdate {
+	t, ok := ParseDependabotTitle(title)
+	if !ok {
+		return nil
+	}
+	return t.Updates
+}
+
+// countDependabotUpdates returns the number of updates announced by the title
+// of a Dependabot PR.
+func countDependabotUpdates(title string) int {
+	t, ok := ParseDependabotTitle(title)
+	if !ok {
+		return 0
+	}
+	if t.Group != "" {
+		return t.UpdateCount
+	}
+	return len(t.Updates)
+}
+
+var (
+	// dependabotBodyBumpRegexp matches the summary of a single update in a
+	// Dependabot PR description, such as "Bumps [lodash](...) from 4.17.20 to
+	// 4.17.21.".
+	dependabotBodyBumpRegexp = regexp.MustCompile(`(?m)^Bumps \[([^\]]+)\]\([^)]*\) from (\S+) to (\S+?)\.?$`)
+	// dependabotBodyUpdatesRegexp matches each member of a grouped update in a
+	// Dependabot PR description, such as "Updates `lodash` from 4.17.20 to
+	// 4.17.21".
+	dependabotBodyUpdatesRegexp = regexp.MustCompile("(?m)^Updates `([^`]+)` from `?([^`\\s]+)`? to `?([^`\\s]+?)`?\\.?$")
+	// dependabotBodyTableRegexp matches the rows of the table of updates in
+	// Dependabot PR descriptions for grouped updates across directories.
+	dependabotBodyTableRegexp = regexp.MustCompile("(?m)^\\|\\s*\\[?([^\\]|]+?)\\]?(?:\\([^)]*\\))?\\s*\\|\\s*`?([^`|]+?)`?\\s*\\|\\s*`?([^`|]+?)`?\\s*\\|\\s*$")
+	// dependabotBodyDirectoryRegexp matches the directory of a grouped update
+	// in a single directory, such as "... with 2 updates in the /web
+	// directory".
+	dependabotBodyDirectoryRegexp = regexp.MustCompile(`(?m)^Bumps the \S+ group with \d+ updates? in the (/\S*) directory`)
+)
+
+func parseDependabotBody(body string) []DependencyUpdate {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+
+	var dir string
+	if m := dependabotBodyDirectoryRegexp.FindStringSubmatch(body); m != nil {
+		dir = m[1]
+	}
+
+	var updates []DependencyUpdate
+	for _, re := range []*regexp.Regexp{dependabotBodyBumpRegexp, dependabotBodyUpdatesRegexp, dependabotBodyTableRegexp} {
+		for _, m := range re.FindAllStringSubmatch(body, -1) {
+			name := strings.TrimSpace(m[1])
+			if name == "Package" || strings.Trim(name, "-: ") == "" {
+				// Skip the table's header and separator rows.
+				continue
+			}
+			updates = append(updates, DependencyUpdate{
+				Name:      name,
+				From:      strings.TrimSpace(m[2]),
+				To:        strings.TrimSpace(m[3]),
+				Directory: dir,
+			})
+		}
+	}
+	return MergeDependencyUpdates(updates)
+}
+
+// dependabotUpdateTypes maps the update types in Dependabot commit metadata to
+// treebot's update types.
+var dependabotUpdateTypes = map[string]string{
+	"version-update:semver-patch": UpdateTypePatch,
+	"version-update:semver-minor": UpdateTypeMinor,
+	"version-update:semver-major": UpdateTypeMajor,
+}
+
+// parseDependabotMetadata parses the "updated-dependencies" metadata in
+// Dependabot commit messages, which looks like:
+//
+//	updated-dependencies:
+//	- dependency-name: golang.org/x/net
+//	  dependency-version: 0.19.0
+//	  dependency-type: direct:production
+//	  update-type: version-update:semver-minor
+//	  dependency-group: go
+func parseDependabotMetadata(message string) []DependencyUpdate {
+	var updates []DependencyUpdate
+	for _, line := range strings.Split(strings.ReplaceAll(message, "\r\n", "\n"), "\n") {
+		line = strings.TrimRight(line, " ")
+		if v := strings.TrimPrefix(line, "- dependency-name: "); v != line {
+			updates = append(updates, DependencyUpdate{Name: strings.TrimSpace(v)})
+			continue
+		}
+		if len(updates) == 0 || !strings.HasPrefix(line, "  ") {
+			continue
+		}
+		key, value, ok := cutString(strings.TrimSpace(line), ": ")
+		if !ok {
+			continue
+		}
+		u := &updates[len(updates)-1]
+		switch key {
+		case "dependency-version":
+			u.To = value
+		case "update-type":
+			u.Type = dependabotUpdateTypes[value]
+		}
+	}
+	return updates
+}
+
+// cutString slices s around the first instance of sep.
+func cutString(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// dependabotEcosystems maps the package managers in Dependabot branch names to
+// the package ecosystems in the Dependabot config, where they differ.
+var dependabotEcosystems = map[string]string{
+	"go_modules":     "gomod",
+	"npm_and_yarn":   "npm",
+	"github_actions": "github-actions",
+	"hex":            "mix",
+	"submodules":     "gitsubmodule",
+}
+
+// parseDependabotEcosystem parses the package ecosystem from a Dependabot
+// branch name such as "dependabot/go_modules/golang.org/x/net-0.19.0".
+func parseDependabotEcosystem(branch string) string {
+	parts := strings.SplitN(branch, "/", 3)
+	if len(parts) < 3 || parts[0] != "dependabot" {
+		return ""
+	}
+	if ecosystem, ok := dependabotEcosystems[parts[1]]; ok {
+		return ecosystem
+	}
+	return parts[1]
+}
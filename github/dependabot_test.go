@@ -0,0 +1,255 @@
+package github
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeDependencyUpdates(t *testing.T) {
+	title := []DependencyUpdate{
+		{Name: "lodash"},
+		{Name: "express"},
+	}
+	body := []DependencyUpdate{
+		{Name: "lodash", From: "4.17.20", To: "4.17.21", Directory: "/web"},
+		{Name: "lodash", From: "4.17.19", To: "4.17.21", Directory: "/api"},
+		{Name: "express", From: "4.18.0", To: "4.19.0", Directory: "/api"},
+	}
+	metadata := []DependencyUpdate{
+		{Name: "lodash", To: "4.17.21", Type: UpdateTypePatch},
+		{Name: "express", To: "4.19.0", Type: UpdateTypeMinor},
+		{Name: "react", To: "18.0.0", Type: UpdateTypeMajor},
+	}
+
+	expected := []DependencyUpdate{
+		{Name: "lodash", From: "4.17.20", To: "4.17.21", Directory: "/web", Type: UpdateTypePatch},
+		{Name: "express", From: "4.18.0", To: "4.19.0", Directory: "/api", Type: UpdateTypeMinor},
+		{Name: "lodash", From: "4.17.19", To: "4.17.21", Directory: "/api", Type: UpdateTypePatch},
+		{Name: "react", To: "18.0.0", Type: UpdateTypeMajor},
+	}
+	if merged := MergeDependencyUpdates(title, body, metadata); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("got %+v, expected %+v", merged, expected)
+	}
+}
+
+func TestDependencyUpdateType(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		expected string
+	}{
+		{"4.17.20", "4.17.21", UpdateTypePatch},
+		{"0.1.0", "0.2.0", UpdateTypeMinor},
+		{"1.9.9", "2.0.0", UpdateTypeMajor},
+		{"v1.2.3", "v1.3.0", UpdateTypeMinor},
+		{"1.2.3-rc.1", "1.2.3", UpdateTypePatch},
+		{"1.2.3+build.5", "1.2.4+build.6", UpdateTypePatch},
+		{"~> 12.3", "~> 13.0", UpdateTypeMajor},
+		{"^1.2", "^1.3", UpdateTypeMinor},
+		{"20", "22", UpdateTypeMajor},
+		{"1.2.3.4", "1.2.3.5", UpdateTypePatch},
+		// Commit SHAs and other non-versions have no update type.
+		{"a1b2c3d", "e4f5a6b", ""},
+		{"1a2b3c4d", "5e6f7a8b", ""},
+		{"0123abc", "4567def", ""},
+		{"master", "main", ""},
+		{"1.2.3", "", ""},
+		{">= 1.0, < 3.0", ">= 1.0, < 4.0", ""},
+	} {
+		u := DependencyUpdate{Name: "dep", From: tc.from, To: tc.to}
+		if actual := u.UpdateType(); actual != tc.expected {
+			t.Errorf("%s -> %s: got '%s', expected '%s'", tc.from, tc.to, actual, tc.expected)
+		}
+	}
+
+	u := DependencyUpdate{Name: "dep", From: "a1b2c3d", To: "e4f5a6b", Type: UpdateTypeMinor}
+	if actual := u.UpdateType(); actual != UpdateTypeMinor {
+		t.Errorf("reported update type: got '%s', expected '%s'", actual, UpdateTypeMinor)
+	}
+}
+
+func TestParseDependabotTitle(t *testing.T) {
+	for _, tc := range []struct {
+		title    string
+		expected DependabotTitle
+	}{
+		{
+			title: "Bump lodash from 4.17.20 to 4.17.21",
+			expected: DependabotTitle{
+				Updates: []DependencyUpdate{{Name: "lodash", From: "4.17.20", To: "4.17.21"}},
+			},
+		},
+		{
+			title: "Bump lodash from 4.17.20 to 4.17.21 in /web",
+			expected: DependabotTitle{
+				Updates:   []DependencyUpdate{{Name: "lodash", From: "4.17.20", To: "4.17.21", Directory: "/web"}},
+				Directory: "/web",
+			},
+		},
+		{
+			title: "[Security] Bump lodash from 4.17.20 to 4.17.21",
+			expected: DependabotTitle{
+				Updates: []DependencyUpdate{{Name: "lodash", From: "4.17.20", To: "4.17.21"}},
+			},
+		},
+		{
+			title: "build(deps): bump lodash from 4.17.20 to 4.17.21",
+			expected: DependabotTitle{
+				Updates: []DependencyUpdate{{Name: "lodash", From: "4.17.20", To: "4.17.21"}},
+			},
+		},
+		{
+			title: "chore(deps-dev)!: [security] bump lodash from 4.17.20 to 4.17.21 in /web",
+			expected: DependabotTitle{
+				Updates:   []DependencyUpdate{{Name: "lodash", From: "4.17.20", To: "4.17.21", Directory: "/web"}},
+				Directory: "/web",
+			},
+		},
+		{
+			title: "[Security] build(deps): Bump lodash from 4.17.20 to 4.17.21",
+			expected: DependabotTitle{
+				Updates: []DependencyUpdate{{Name: "lodash", From: "4.17.20", To: "4.17.21"}},
+			},
+		},
+		{
+			title: "Update rake requirement from ~> 12.3 to ~> 13.0",
+			expected: DependabotTitle{
+				Updates: []DependencyUpdate{{Name: "rake", From: "~> 12.3", To: "~> 13.0"}},
+			},
+		},
+		{
+			title: "Bump @babel/core and @babel/preset-env in /web",
+			expected: DependabotTitle{
+				Updates: []DependencyUpdate{
+					{Name: "@babel/core", Directory: "/web"},
+					{Name: "@babel/preset-env", Directory: "/web"},
+				},
+				Directory: "/web",
+			},
+		},
+		{
+			title: "Bump a, b and c",
+			expected: DependabotTitle{
+				Updates: []DependencyUpdate{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			},
+		},
+		{
+			title:    "Bump the go group with 3 updates",
+			expected: DependabotTitle{Group: "go", UpdateCount: 3},
+		},
+		{
+			title:    "Bump the npm group in /web with 1 update",
+			expected: DependabotTitle{Group: "npm", Directory: "/web", UpdateCount: 1},
+		},
+		{
+			title:    "Bump the aws group across 3 directories with 7 updates",
+			expected: DependabotTitle{Group: "aws", UpdateCount: 7, DirectoryCount: 3},
+		},
+		{
+			title:    "[Security] Bump the go group with 2 updates",
+			expected: DependabotTitle{Group: "go", UpdateCount: 2},
+		},
+	} {
+		actual, ok := ParseDependabotTitle(tc.title)
+		if !ok {
+			t.Errorf("'%s': expected the title to be parsed", tc.title)
+			continue
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("'%s': got %+v, expected %+v", tc.title, actual, tc.expected)
+		}
+	}
+
+	for _, title := range []string{
+		"Add a feature",
+		"Bump version to 1.2.3 in the changelog",
+		"Revert \"Bump lodash from 4.17.20 to 4.17.21\"",
+	} {
+		if actual, ok := ParseDependabotTitle(title); ok {
+			t.Errorf("'%s': expected the title not to be parsed, but got %+v", title, actual)
+		}
+	}
+}
+
+func TestParseDependabotBody(t *testing.T) {
+	for name, tc := range map[string]struct {
+		body     string
+		expected []DependencyUpdate
+	}{
+		"Single": {
+			body: "Bumps [lodash](https://github.com/lodash/lodash) from 4.17.20 to 4.17.21.\r\n- [Release notes](https://github.com/lodash/lodash/releases)\r\n",
+			expected: []DependencyUpdate{
+				{Name: "lodash", From: "4.17.20", To: "4.17.21"},
+			},
+		},
+		"Group": {
+			body: "Bumps the go group with 2 updates in the /tools directory: [golang.org/x/net](https://github.com/golang/net) and [golang.org/x/sys](https://github.com/golang/sys).\n\n" +
+				"Updates `golang.org/x/net` from 0.17.0 to 0.19.0\n- [Commits](https://github.com/golang/net/compare/v0.17.0...v0.19.0)\n\n" +
+				"Updates `golang.org/x/sys` from 0.14.0 to 0.15.0\n- [Commits](https://github.com/golang/sys/compare/v0.14.0...v0.15.0)\n",
+			expected: []DependencyUpdate{
+				{Name: "golang.org/x/net", From: "0.17.0", To: "0.19.0", Directory: "/tools"},
+				{Name: "golang.org/x/sys", From: "0.14.0", To: "0.15.0", Directory: "/tools"},
+			},
+		},
+		"Table": {
+			body: "Bumps the aws group with 2 updates in the / and /infra directories:\n\n" +
+				"| Package | From | To |\n| --- | --- | --- |\n" +
+				"| [aws-sdk](https://github.com/aws/aws-sdk-js) | `2.1.0` | `2.2.0` |\n" +
+				"| [boto3](https://github.com/boto/boto3) | `1.0.0` | `1.1.0` |\n",
+			expected: []DependencyUpdate{
+				{Name: "aws-sdk", From: "2.1.0", To: "2.2.0"},
+				{Name: "boto3", From: "1.0.0", To: "1.1.0"},
+			},
+		},
+		"Unrelated": {
+			body: "This PR adds a feature.",
+		},
+	} {
+		if actual := parseDependabotBody(tc.body); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%s: got %+v, expected %+v", name, actual, tc.expected)
+		}
+	}
+}
+
+func TestParseDependabotMetadata(t *testing.T) {
+	message := "Bump the go group with 2 updates\r\n\r\n" +
+		"Bumps the go group with 2 updates.\r\n\r\n" +
+		"---\r\n" +
+		"updated-dependencies:\r\n" +
+		"- dependency-name: golang.org/x/net\r\n" +
+		"  dependency-version: 0.19.0\r\n" +
+		"  dependency-type: direct:production\r\n" +
+		"  update-type: version-update:semver-minor\r\n" +
+		"  dependency-group: go\r\n" +
+		"- dependency-name: golang.org/x/sys\r\n" +
+		"  dependency-type: indirect\r\n" +
+		"  update-type: version-update:semver-patch\r\n" +
+		"...\r\n\r\n" +
+		"Signed-off-by: dependabot[bot] <support@github.com>\r\n"
+
+	expected := []DependencyUpdate{
+		{Name: "golang.org/x/net", To: "0.19.0", Type: UpdateTypeMinor},
+		{Name: "golang.org/x/sys", Type: UpdateTypePatch},
+	}
+	if actual := parseDependabotMetadata(message); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got %+v, expected %+v", actual, expected)
+	}
+
+	if actual := parseDependabotMetadata("Bump lodash from 4.17.20 to 4.17.21"); actual != nil {
+		t.Errorf("expected no updates from a message without metadata, but got %+v", actual)
+	}
+}
+
+func TestParseDependabotEcosystem(t *testing.T) {
+	for branch, expected := range map[string]string{
+		"dependabot/go_modules/golang.org/x/net-0.19.0": "gomod",
+		"dependabot/npm_and_yarn/web/lodash-4.17.21":    "npm",
+		"dependabot/pip/requests-2.31.0":                "pip",
+		"dependabot/github_actions/actions/checkout-4":  "github-actions",
+		"renovate/lodash-4.x":                           "",
+		"dependabot/pip":                                "",
+	} {
+		if actual := parseDependabotEcosystem(branch); actual != expected {
+			t.Errorf("'%s': got '%s', expected '%s'", branch, actual, expected)
+		}
+	}
+}
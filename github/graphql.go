@@ -0,0 +1,379 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v40/github"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// graphQLBatchSize is the maximum number of PRs fetched in a single GraphQL
+// query. Larger queries risk hitting GitHub's query complexity and timeout
+// limits.
+const graphQLBatchSize = 20
+
+// PullRequestSnapshot is the state of a PR's head commit and reviews, fetched
+// in bulk from the GraphQL API.
+type PullRequestSnapshot struct {
+	// HeadSHA is the head commit that the statuses belong to.
+	HeadSHA string
+	// Statuses are the latest commit status for each status context on the
+	// head commit.
+	Statuses []github.RepoStatus
+	// RollupState is the overall state of all commit statuses and check runs
+	// on the head commit.
+	RollupState string
+	Reviews     []PullRequestReview
+}
+
+type PullRequestReview struct {
+	Author string
+	State  string
+}
+
+// prRef identifies a PR in a repo.
+type prRef struct {
+	owner  string
+	repo   string
+	number int
+}
+
+// parsePRRef parses a PR API URL of the form
+// https://api.github.com/repos/{owner}/{repo}/pulls/{number}.
+func parsePRRef(apiURL string) (prRef, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return prRef{}, errors.Wrap(err, "parsing URL")
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 5 || parts[len(parts)-5] != "repos" || parts[len(parts)-2] != "pulls" {
+		return prRef{}, errors.Errorf("'%s' is not a PR API URL", apiURL)
+	}
+	num, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return prRef{}, errors.Wrap(err, "parsing PR number")
+	}
+
+	return prRef{
+		owner:  parts[len(parts)-4],
+		repo:   parts[len(parts)-3],
+		number: num,
+	}, nil
+}
+
+const graphQLPullRequestFragment = `
+fragment PullRequestFields on PullRequest {
+  number
+  title
+  body
+  url
+  state
+  merged
+  mergeable
+  mergeStateStatus
+  isDraft
+  createdAt
+  updatedAt
+  author { login __typename }
+  baseRefName
+  headRefName
+  headRefOid
+  commits { totalCount }
+  labels(first: 50) { nodes { name } }
+  reviews(last: 50) { nodes { state author { login } } }
+  headCommit: commits(last: 1) {
+    nodes {
+      commit {
+        oid
+        statusCheckRollup {
+          state
+          contexts(first: 100) {
+            nodes {
+              __typename
+              ... on StatusContext { context state description targetUrl createdAt }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type graphQLPullRequest struct {
+	Number           int       `json:"number"`
+	Title            string    `json:"title"`
+	Body             string    `json:"body"`
+	URL              string    `json:"url"`
+	State            string    `json:"state"`
+	Merged           bool      `json:"merged"`
+	Mergeable        string    `json:"mergeable"`
+	MergeStateStatus string    `json:"mergeStateStatus"`
+	IsDraft          bool      `json:"isDraft"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	Author           *struct {
+		Login    string `json:"login"`
+		TypeName string `json:"__typename"`
+	} `json:"author"`
+	BaseRefName string `json:"baseRefName"`
+	HeadRefName string `json:"headRefName"`
+	HeadRefOid  string `json:"headRefOid"`
+	Commits     struct {
+		TotalCount int `json:"totalCount"`
+	} `json:"commits"`
+	Labels struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"labels"`
+	Reviews struct {
+		Nodes []struct {
+			State  string `json:"state"`
+			Author *struct {
+				Login string `json:"login"`
+			} `json:"author"`
+		} `json:"nodes"`
+	} `json:"reviews"`
+	HeadCommit struct {
+		Nodes []struct {
+			Commit struct {
+				Oid               string `json:"oid"`
+				StatusCheckRollup *struct {
+					State    string `json:"state"`
+					Contexts struct {
+						Nodes []struct {
+							TypeName    string    `json:"__typename"`
+							Context     string    `json:"context"`
+							State       string    `json:"state"`
+							Description string    `json:"description"`
+							TargetURL   string    `json:"targetUrl"`
+							CreatedAt   time.Time `json:"createdAt"`
+						} `json:"nodes"`
+					} `json:"contexts"`
+				} `json:"statusCheckRollup"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"headCommit"`
+}
+
+// toREST converts the GraphQL PR into the equivalent REST API PR and snapshot
+// of its head commit. The PR only has the fields that treebot checks; in
+// particular, its base and head branches have no repos.
+func (gpr *graphQLPullRequest) toREST(ref prRef, apiURL string) (github.PullRequest, PullRequestSnapshot) {
+	repoAPIURL := fmt.Sprintf("https://api.github.com/repos/%s/%s", ref.owner, ref.repo)
+
+	createdAt, updatedAt := gpr.CreatedAt, gpr.UpdatedAt
+	state := PRStateOpen
+	if gpr.State != "OPEN" {
+		state = PRStateClosed
+	}
+	pr := github.PullRequest{
+		Number:         github.Int(gpr.Number),
+		Title:          github.String(gpr.Title),
+		Body:           github.String(gpr.Body),
+		URL:            github.String(apiURL),
+		HTMLURL:        github.String(gpr.URL),
+		State:          github.String(state),
+		Merged:         github.Bool(gpr.Merged),
+		MergeableState: github.String(strings.ToLower(gpr.MergeStateStatus)),
+		Draft:          github.Bool(gpr.IsDraft),
+		CreatedAt:      &createdAt,
+		UpdatedAt:      &updatedAt,
+		Commits:        github.Int(gpr.Commits.TotalCount),
+		CommitsURL:     github.String(apiURL + "/commits"),
+		StatusesURL:    github.String(fmt.Sprintf("%s/statuses/%s", repoAPIURL, gpr.HeadRefOid)),
+		Base:           &github.PullRequestBranch{Ref: github.String(gpr.BaseRefName)},
+		Head:           &github.PullRequestBranch{Ref: github.String(gpr.HeadRefName), SHA: github.String(gpr.HeadRefOid)},
+	}
+	switch gpr.Mergeable {
+	case "MERGEABLE":
+		pr.Mergeable = github.Bool(true)
+	case "CONFLICTING":
+		pr.Mergeable = github.Bool(false)
+	}
+	if gpr.Author != nil {
+		pr.User = &github.User{
+			Login: github.String(gpr.Author.Login),
+			Type:  github.String(gpr.Author.TypeName),
+		}
+		// Bot logins in the GraphQL API don't include the "[bot]" suffix
+		// that's present in the REST API.
+		if gpr.Author.TypeName == string(UserTypeBot) && !strings.HasSuffix(gpr.Author.Login, "[bot]") {
+			pr.User.Login = github.String(gpr.Author.Login + "[bot]")
+		}
+	}
+	for _, l := range gpr.Labels.Nodes {
+		pr.Labels = append(pr.Labels, &github.Label{Name: github.String(l.Name)})
+	}
+
+	snapshot := PullRequestSnapshot{HeadSHA: gpr.HeadRefOid}
+	for _, r := range gpr.Reviews.Nodes {
+		review := PullRequestReview{State: r.State}
+		if r.Author != nil {
+			review.Author = r.Author.Login
+		}
+		snapshot.Reviews = append(snapshot.Reviews, review)
+	}
+	if len(gpr.HeadCommit.Nodes) != 0 {
+		commit := gpr.HeadCommit.Nodes[0].Commit
+		snapshot.HeadSHA = commit.Oid
+		if rollup := commit.StatusCheckRollup; rollup != nil {
+			snapshot.RollupState = graphQLStatusState(rollup.State)
+			for _, c := range rollup.Contexts.Nodes {
+				if c.TypeName != "StatusContext" {
+					continue
+				}
+				statusCreatedAt := c.CreatedAt
+				snapshot.Statuses = append(snapshot.Statuses, github.RepoStatus{
+					Context:     github.String(c.Context),
+					State:       github.String(graphQLStatusState(c.State)),
+					Description: github.String(c.Description),
+					TargetURL:   github.String(c.TargetURL),
+					CreatedAt:   &statusCreatedAt,
+				})
+			}
+		}
+	}
+
+	return pr, snapshot
+}
+
+// graphQLStatusState converts a GraphQL status state to the equivalent REST
+// API commit status state.
+func graphQLStatusState(state string) string {
+	if state == "EXPECTED" {
+		return CommitStatusPending
+	}
+	return strings.ToLower(state)
+}
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string        `json:"message"`
+		Path    []interface{} `json:"path"`
+	} `json:"errors"`
+}
+
+// getPRNotificationsGraphQL fetches the PRs for the notifications in batches
+// using the GraphQL API. Notifications whose PR could not be fetched through
+// GraphQL are fetched from the REST API instead.
+func (c *Client) getPRNotificationsGraphQL(ctx context.Context, notifications []github.Notification) ([]PullRequestNotification, error) {
+	prNotifications := make([]PullRequestNotification, len(notifications))
+	found := make([]bool, len(notifications))
+
+	for start := 0; start < len(notifications); start += graphQLBatchSize {
+		end := start + graphQLBatchSize
+		if end > len(notifications) {
+			end = len(notifications)
+		}
+		if err := c.getPRNotificationsGraphQLBatch(ctx, notifications[start:end], prNotifications[start:end], found[start:end]); err != nil {
+			return nil, errors.Wrap(err, "fetching batch of PRs")
+		}
+	}
+
+	for i, n := range notifications {
+		if found[i] {
+			continue
+		}
+		zap.S().Debugf("%s: falling back to REST API to get PR", GetLogFormat(n))
+		pr, err := c.GetPRFromNotification(ctx, n)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting PR for notification '%s'", GetLogFormat(n))
+		}
+		prNotifications[i] = PullRequestNotification{
+			Notification: n,
+			PullRequest:  *pr,
+		}
+	}
+
+	return prNotifications, nil
+}
+
+func (c *Client) getPRNotificationsGraphQLBatch(ctx context.Context, notifications []github.Notification, prNotifications []PullRequestNotification, found []bool) error {
+	var params, fields []string
+	vars := map[string]interface{}{}
+	refs := make([]prRef, len(notifications))
+	for i, n := range notifications {
+		ref, err := parsePRRef(n.Subject.GetURL())
+		if err != nil {
+			zap.S().Debugf("%s: cannot get PR using GraphQL: %s", GetLogFormat(n), err)
+			continue
+		}
+		refs[i] = ref
+
+		params = append(params, fmt.Sprintf("$owner%d: String!, $name%d: String!, $number%d: Int!", i, i, i))
+		fields = append(fields, fmt.Sprintf("pr%d: repository(owner: $owner%d, name: $name%d) { pullRequest(number: $number%d) { ...PullRequestFields } }", i, i, i, i))
+		vars[fmt.Sprintf("owner%d", i)] = ref.owner
+		vars[fmt.Sprintf("name%d", i)] = ref.repo
+		vars[fmt.Sprintf("number%d", i)] = ref.number
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf("query(%s) {\n%s\n}\n%s", strings.Join(params, ", "), strings.Join(fields, "\n"), graphQLPullRequestFragment)
+	req, err := c.NewRequest(http.MethodPost, "graphql", graphQLRequest{Query: query, Variables: vars})
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+
+	var res graphQLResponse
+	resp, err := c.Do(ctx, req, &res)
+	if err != nil {
+		return errors.Wrap(err, "requesting PR information")
+	}
+	defer resp.Body.Close()
+
+	for _, e := range res.Errors {
+		// Errors for individual PRs (e.g. if a PR can't be found) leave that
+		// PR's data empty, so it will be fetched from the REST API.
+		zap.S().Debugw("GraphQL query returned error",
+			"message", e.Message,
+			"path", e.Path,
+		)
+	}
+	if res.Data == nil {
+		return errors.New("GraphQL query returned no data")
+	}
+
+	for i, n := range notifications {
+		raw, ok := res.Data[fmt.Sprintf("pr%d", i)]
+		if !ok {
+			continue
+		}
+		var repo struct {
+			PullRequest *graphQLPullRequest `json:"pullRequest"`
+		}
+		if err := json.Unmarshal(raw, &repo); err != nil || repo.PullRequest == nil {
+			continue
+		}
+
+		// The PR isn't memoized because it's missing some fields, such as
+		// the repos of its base and head branches, that callers of
+		// GetPRFromNotification expect.
+		pr, snapshot := repo.PullRequest.toREST(refs[i], n.Subject.GetURL())
+		prNotifications[i] = PullRequestNotification{
+			Notification: n,
+			PullRequest:  pr,
+			Snapshot:     &snapshot,
+		}
+		found[i] = true
+	}
+
+	return nil
+}
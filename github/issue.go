@@ -0,0 +1,178 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v40/github"
+	"github.com/pkg/errors"
+)
+
+// ListPRComments lists all the comments on the PR's conversation.
+func (c *Client) ListPRComments(ctx context.Context, n PullRequestNotification) ([]*github.IssueComment, error) {
+	owner := n.Notification.Repository.Owner.GetLogin()
+	repo := n.Notification.Repository.GetName()
+	prNum := n.PullRequest.GetNumber()
+
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var comments []*github.IssueComment
+	for {
+		page, resp, err := c.Issues.ListComments(ctx, owner, repo, prNum, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing PR comments")
+		}
+		resp.Body.Close()
+
+		comments = append(comments, page...)
+		if resp.NextPage == 0 {
+			return comments, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// CreatePRComment adds a comment to the PR's conversation.
+func (c *Client) CreatePRComment(ctx context.Context, n PullRequestNotification, body string) (*github.IssueComment, error) {
+	owner := n.Notification.Repository.Owner.GetLogin()
+	repo := n.Notification.Repository.GetName()
+	prNum := n.PullRequest.GetNumber()
+
+	comment, resp, err := c.Issues.CreateComment(ctx, owner, repo, prNum, &github.IssueComment{Body: github.String(body)})
+	if err != nil {
+		return nil, errors.Wrap(err, "creating PR comment")
+	}
+	defer resp.Body.Close()
+
+	return comment, nil
+}
+
+// UpsertPRComment makes sure that the PR has exactly one comment containing
+// the marker, with the given body. The body must contain the marker. If the
+// comment already exists with the same body, it's left as is.
+func (c *Client) UpsertPRComment(ctx context.Context, n PullRequestNotification, marker, body string) error {
+	owner := n.Notification.Repository.Owner.GetLogin()
+	repo := n.Notification.Repository.GetName()
+
+	existing, err := c.findPRComment(ctx, n, marker)
+	if err != nil {
+		return errors.Wrap(err, "finding existing comment")
+	}
+	if existing == nil {
+		_, err := c.CreatePRComment(ctx, n, body)
+		return err
+	}
+	if existing.GetBody() == body {
+		return nil
+	}
+
+	_, resp, err := c.Issues.EditComment(ctx, owner, repo, existing.GetID(), &github.IssueComment{Body: github.String(body)})
+	if err != nil {
+		return errors.Wrap(err, "editing PR comment")
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// DeletePRComment deletes the PR comment containing the marker, if there is
+// one.
+func (c *Client) DeletePRComment(ctx context.Context, n PullRequestNotification, marker string) error {
+	owner := n.Notification.Repository.Owner.GetLogin()
+	repo := n.Notification.Repository.GetName()
+
+	existing, err := c.findPRComment(ctx, n, marker)
+	if err != nil {
+		return errors.Wrap(err, "finding existing comment")
+	}
+	if existing == nil {
+		return nil
+	}
+
+	resp, err := c.Issues.DeleteComment(ctx, owner, repo, existing.GetID())
+	if err != nil {
+		return errors.Wrap(err, "deleting PR comment")
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+func (c *Client) findPRComment(ctx context.Context, n PullRequestNotification, marker string) (*github.IssueComment, error) {
+	comments, err := c.ListPRComments(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	for _, comment := range comments {
+		if strings.Contains(comment.GetBody(), marker) {
+			return comment, nil
+		}
+	}
+	return nil, nil
+}
+
+// Reactions to comments.
+const (
+	ReactionThumbsUp = "+1"
+	ReactionConfused = "confused"
+)
+
+// ReactToPRComment adds a reaction to a comment on the PR's conversation.
+func (c *Client) ReactToPRComment(ctx context.Context, n PullRequestNotification, commentID int64, reaction string) error {
+	owner := n.Notification.Repository.Owner.GetLogin()
+	repo := n.Notification.Repository.GetName()
+
+	_, resp, err := c.Reactions.CreateIssueCommentReaction(ctx, owner, repo, commentID, reaction)
+	if err != nil {
+		return errors.Wrap(err, "reacting to PR comment")
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// HasLabel returns whether the PR has the label.
+func HasLabel(pr github.PullRequest, label string) bool {
+	for _, l := range pr.Labels {
+		if l.GetName() == label {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPRLabel adds the label to the PR.
+func (c *Client) AddPRLabel(ctx context.Context, n PullRequestNotification, label string) error {
+	owner := n.Notification.Repository.Owner.GetLogin()
+	repo := n.Notification.Repository.GetName()
+	prNum := n.PullRequest.GetNumber()
+
+	_, resp, err := c.Issues.AddLabelsToIssue(ctx, owner, repo, prNum, []string{label})
+	if err != nil {
+		return errors.Wrap(err, "adding label to PR")
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// RemovePRLabel removes the label from the PR. It's not an error if the PR
+// doesn't have the label.
+func (c *Client) RemovePRLabel(ctx context.Context, n PullRequestNotification, label string) error {
+	owner := n.Notification.Repository.Owner.GetLogin()
+	repo := n.Notification.Repository.GetName()
+	prNum := n.PullRequest.GetNumber()
+
+	resp, err := c.Issues.RemoveLabelForIssue(ctx, owner, repo, prNum, label)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "removing label from PR")
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
@@ -3,6 +3,7 @@ package github
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"regexp"
 	"time"
 
@@ -54,6 +55,9 @@ type NotificationOptions struct {
 	IncludeReasons []string
 	IncludeTypes   []NotificationType
 	IncludeUsers   []NotificationFromUserOptions
+	// UseGraphQL fetches the PRs for notifications in bulk using the GraphQL
+	// API, falling back to the REST API if that fails.
+	UseGraphQL bool
 }
 
 type UserType string
@@ -73,7 +77,11 @@ type NotificationFromUserOptions struct {
 func (c *Client) GetNotifications(ctx context.Context, opts NotificationOptions) ([]github.Notification, error) {
 	var titleMatcher titleFilters
 	for _, expr := range opts.IncludeTitles {
-		titleMatcher.matches = append(titleMatcher.matches, regexp.MustCompile(expr))
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compiling title pattern '%s'", expr)
+		}
+		titleMatcher.matches = append(titleMatcher.matches, re)
 	}
 	reasonMatcher := reasonFilters{reasons: opts.IncludeReasons}
 	typeMatcher := typeFilters{types: opts.IncludeTypes}
@@ -135,9 +143,39 @@ func (c *Client) GetNotifications(ctx context.Context, opts NotificationOptions)
 type PullRequestNotification struct {
 	Notification github.Notification
 	PullRequest  github.PullRequest
+	// Snapshot is the state of the PR's head commit, if it was fetched in bulk
+	// along with the PR.
+	Snapshot *PullRequestSnapshot
+}
+
+// NewPullRequestNotification creates a notification for a PR that was fetched
+// directly rather than through the notifications API, so that it can be
+// checked and acted on like any other PR notification.
+func NewPullRequestNotification(pr github.PullRequest) PullRequestNotification {
+	return PullRequestNotification{
+		Notification: github.Notification{
+			Repository: pr.GetBase().GetRepo(),
+			Subject: &github.NotificationSubject{
+				Title: pr.Title,
+				URL:   pr.URL,
+				Type:  github.String(string(NotificationTypePullRequest)),
+			},
+		},
+		PullRequest: pr,
+	}
 }
 
 func (c *Client) GetPRNotifications(ctx context.Context, opts NotificationOptions) ([]PullRequestNotification, error) {
+	if opts.UseGraphQL {
+		prNotifications, err := c.getPRNotificationsUsingGraphQL(ctx, opts)
+		if err == nil {
+			return prNotifications, nil
+		}
+		zap.S().Warnw("could not get PRs using the GraphQL API, falling back to the REST API",
+			"error", err,
+		)
+	}
+
 	notifications, err := c.GetNotifications(ctx, opts)
 	if err != nil {
 		return nil, errors.Wrap(err, "getting notifications")
@@ -162,6 +200,40 @@ func (c *Client) GetPRNotifications(ctx context.Context, opts NotificationOption
 	return prNotifications, nil
 }
 
+// getPRNotificationsUsingGraphQL is the same as GetPRNotifications, but
+// fetches the PRs in bulk. Since the PRs are already fetched in bulk, the user
+// filter is applied to the fetched PRs rather than fetching each PR
+// separately while filtering notifications.
+func (c *Client) getPRNotificationsUsingGraphQL(ctx context.Context, opts NotificationOptions) ([]PullRequestNotification, error) {
+	userMatcher := notificationsFromUserFilter{users: opts.IncludeUsers}
+	opts.IncludeUsers = nil
+
+	notifications, err := c.GetNotifications(ctx, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting notifications")
+	}
+
+	if len(notifications) > 0 {
+		zap.S().Debug("fetching pull request information for candidate notifications using GraphQL")
+	}
+
+	prNotifications, err := c.getPRNotificationsGraphQL(ctx, notifications)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting PRs for notifications")
+	}
+
+	var filtered []PullRequestNotification
+	for _, n := range prNotifications {
+		if !userMatcher.matchesPR(n.PullRequest) {
+			zap.S().Debugf("%s: skipping notification due to unmatched user", GetLogFormat(n.Notification))
+			continue
+		}
+		filtered = append(filtered, n)
+	}
+
+	return filtered, nil
+}
+
 type titleFilters struct {
 	matches []*regexp.Regexp
 }
@@ -238,25 +310,73 @@ func (f *notificationsFromUserFilter) shouldAdd(ctx context.Context, c *Client,
 			return false, errors.Wrap(err, "getting PR metadata from notification")
 		}
 
-		for _, u := range f.users {
-			if u.Name != "" {
-				if u.Name != pr.User.GetLogin() {
-					continue
-				}
-			}
+		return f.matchesPR(*pr), nil
+	default:
+		return false, nil
+	}
+}
 
-			if u.Type != "" {
-				if string(u.Type) != pr.User.GetType() {
-					continue
-				}
+func (f *notificationsFromUserFilter) matchesPR(pr github.PullRequest) bool {
+	if len(f.users) == 0 {
+		return true
+	}
+
+	for _, u := range f.users {
+		if u.Name != "" {
+			if u.Name != pr.User.GetLogin() {
+				continue
 			}
+		}
 
-			return true, nil
+		if u.Type != "" {
+			if string(u.Type) != pr.User.GetType() {
+				continue
+			}
 		}
-		return false, nil
-	default:
-		return false, nil
+
+		return true
+	}
+	return false
+}
+
+// MarkNotificationRead marks the notification's thread as read.
+func (c *Client) MarkNotificationRead(ctx context.Context, n github.Notification) error {
+	resp, err := c.Activity.MarkThreadRead(ctx, n.GetID())
+	if err != nil {
+		return errors.Wrap(err, "marking notification thread as read")
 	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// MarkNotificationDone marks the notification's thread as done, which removes
+// it from the inbox.
+func (c *Client) MarkNotificationDone(ctx context.Context, n github.Notification) error {
+	req, err := c.NewRequest(http.MethodDelete, fmt.Sprintf("notifications/threads/%s", n.GetID()), nil)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+
+	resp, err := c.Do(ctx, req, nil)
+	if err != nil {
+		return errors.Wrap(err, "marking notification thread as done")
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// UnsubscribeFromNotification stops notifications for the notification's
+// thread.
+func (c *Client) UnsubscribeFromNotification(ctx context.Context, n github.Notification) error {
+	resp, err := c.Activity.DeleteThreadSubscription(ctx, n.GetID())
+	if err != nil {
+		return errors.Wrap(err, "deleting notification thread subscription")
+	}
+	defer resp.Body.Close()
+
+	return nil
 }
 
 func GetLogFormat(n github.Notification) string {
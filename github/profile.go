@@ -0,0 +1,236 @@
+package github
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v40/github"
+	"github.com/pkg/errors"
+)
+
+// DependencyUpdate is a single dependency update proposed by a bot.
+type DependencyUpdate struct {
+	Name string
+	// From and To are the old and new versions of the dependency, if known.
+	From string
+	To   string
+	// Directory is the directory of the updated manifest, if known.
+	Directory string
+	// Type is the type of the version update as reported by the bot, if
+	// known.
+	Type string
+}
+
+// BotProfile describes a bot whose PRs treebot acts on.
+type BotProfile struct {
+	Name     string
+	Username string
+	// UserType is the type of the bot's GitHub account. If it's empty, any
+	// type matches.
+	UserType UserType
+	// BranchPrefix is the prefix of the branches that the bot opens PRs from.
+	// If it's empty, any branch matches.
+	BranchPrefix string
+	// ParseTitle extracts the dependency updates from the title of one of the
+	// bot's PRs. It returns nil if the title isn't recognized.
+	ParseTitle func(title string) []DependencyUpdate
+	// CountUpdates returns the number of dependency updates that the title of
+	// one of the bot's PRs says the PR contains. It returns 0 if the title
+	// doesn't say.
+	CountUpdates func(title string) int
+	// ParseBody extracts the dependency updates from the description of one
+	// of the bot's PRs. It returns nil if the description isn't recognized.
+	ParseBody func(body string) []DependencyUpdate
+	// ParseMetadata extracts the dependency updates from the metadata that the
+	// bot puts in its commit messages. It returns nil if there is no metadata.
+	ParseMetadata func(message string) []DependencyUpdate
+	// ParseEcosystem extracts the package ecosystem from the name of the
+	// branch of one of the bot's PRs. It returns an empty string if the
+	// ecosystem isn't known.
+	ParseEcosystem func(branch string) string
+	// RebaseCommand and RecreateCommand are the PR comments that ask the bot
+	// to rebase or recreate its PR. They're empty if the bot doesn't support
+	// them.
+	RebaseCommand   string
+	RecreateCommand string
+}
+
+// MatchesPR returns whether the PR was opened by the bot.
+func (p BotProfile) MatchesPR(pr github.PullRequest) bool {
+	if pr.GetUser().GetLogin() != p.Username {
+		return false
+	}
+	if p.UserType != "" && pr.GetUser().GetType() != string(p.UserType) {
+		return false
+	}
+	if p.BranchPrefix != "" && !strings.HasPrefix(pr.GetHead().GetRef(), p.BranchPrefix) {
+		return false
+	}
+	return true
+}
+
+// UpdatesFromTitle returns the dependency updates described by the title of
+// one of the bot's PRs.
+func (p BotProfile) UpdatesFromTitle(title string) []DependencyUpdate {
+	if p.ParseTitle == nil {
+		return nil
+	}
+	return p.ParseTitle(title)
+}
+
+// UpdateCount returns the number of dependency updates that the title of one
+// of the bot's PRs says the PR contains, or 0 if it's not known.
+func (p BotProfile) UpdateCount(title string) int {
+	if p.CountUpdates == nil {
+		return 0
+	}
+	return p.CountUpdates(title)
+}
+
+// UpdatesComplete returns whether the updates parsed so far fully describe
+// the PR with the title: the PR's updates must all have been found and the
+// type of each of them must be known. A grouped update whose description was
+// truncated lists fewer updates than its title announces.
+func (p BotProfile) UpdatesComplete(title string, updates []DependencyUpdate) bool {
+	if len(updates) == 0 || len(updates) < p.UpdateCount(title) {
+		return false
+	}
+	for _, u := range updates {
+		if u.UpdateType() == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdatesFromBody returns the dependency updates described by the
+// description of one of the bot's PRs.
+func (p BotProfile) UpdatesFromBody(body string) []DependencyUpdate {
+	if p.ParseBody == nil {
+		return nil
+	}
+	return p.ParseBody(body)
+}
+
+// UpdatesFromMetadata returns the dependency updates described by the
+// metadata in one of the bot's commit messages.
+func (p BotProfile) UpdatesFromMetadata(message string) []DependencyUpdate {
+	if p.ParseMetadata == nil {
+		return nil
+	}
+	return p.ParseMetadata(message)
+}
+
+// Ecosystem returns the package ecosystem of the dependencies that one of the
+// bot's PRs updates, if known.
+func (p BotProfile) Ecosystem(pr github.PullRequest) string {
+	if p.ParseEcosystem == nil {
+		return ""
+	}
+	return p.ParseEcosystem(pr.GetHead().GetRef())
+}
+
+// NewRegexpTitleParser returns a title parser that matches titles against the
+// pattern. The pattern must have a capture group named "name" for the
+// dependency name, and can have capture groups named "from" and "to" for the
+// versions.
+func NewRegexpTitleParser(pattern string) (func(title string) []DependencyUpdate, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "compiling title pattern")
+	}
+	if re.SubexpIndex("name") < 0 {
+		return nil, errors.Errorf("title pattern '%s' must have a capture group named 'name'", pattern)
+	}
+	return regexpTitleParser(re), nil
+}
+
+func regexpTitleParser(re *regexp.Regexp) func(title string) []DependencyUpdate {
+	return func(title string) []DependencyUpdate {
+		m := re.FindStringSubmatch(title)
+		if m == nil {
+			return nil
+		}
+		return []DependencyUpdate{regexpUpdate(re, m)}
+	}
+}
+
+// NewRegexpMetadataParser returns a commit metadata parser that finds every
+// match of the pattern in a commit message. The pattern must have a capture
+// group named "name" for the dependency name, and can have capture groups
+// named "from" and "to" for the versions and "type" for the update type.
+func NewRegexpMetadataParser(pattern string) (func(message string) []DependencyUpdate, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "compiling metadata pattern")
+	}
+	if re.SubexpIndex("name") < 0 {
+		return nil, errors.Errorf("metadata pattern '%s' must have a capture group named 'name'", pattern)
+	}
+	return func(message string) []DependencyUpdate {
+		var updates []DependencyUpdate
+		for _, m := range re.FindAllStringSubmatch(message, -1) {
+			updates = append(updates, regexpUpdate(re, m))
+		}
+		return updates
+	}, nil
+}
+
+// regexpUpdate returns the dependency update in the capture groups of the
+// match. An update type that isn't known is left out.
+func regexpUpdate(re *regexp.Regexp, m []string) DependencyUpdate {
+	group := func(name string) string {
+		if i := re.SubexpIndex(name); i >= 0 {
+			return m[i]
+		}
+		return ""
+	}
+	u := DependencyUpdate{
+		Name: group("name"),
+		From: group("from"),
+		To:   group("to"),
+	}
+	updateType := strings.ToLower(group("type"))
+	for _, t := range UpdateTypes() {
+		if t == updateType {
+			u.Type = t
+		}
+	}
+	return u
+}
+
+const RenovateUsername = "renovate[bot]"
+
+// renovateTitleRegexp matches Renovate PR titles such as "Update dependency
+// lodash to v4.17.21" or "chore(deps): update module ...".
+var renovateTitleRegexp = regexp.MustCompile(`(?i)\bupdate (?:dependency |module )?(?P<name>\S+) to (?P<to>v?\d\S*)`)
+
+// DependabotProfile is the profile of GitHub's Dependabot.
+var DependabotProfile = BotProfile{
+	Name:            "dependabot",
+	Username:        DependabotUsername,
+	UserType:        UserTypeBot,
+	BranchPrefix:    "dependabot/",
+	ParseTitle:      parseDependabotTitle,
+	CountUpdates:    countDependabotUpdates,
+	ParseBody:       parseDependabotBody,
+	ParseMetadata:   parseDependabotMetadata,
+	ParseEcosystem:  parseDependabotEcosystem,
+	RebaseCommand:   "@dependabot rebase",
+	RecreateCommand: "@dependabot recreate",
+}
+
+// RenovateProfile is the profile of the Renovate GitHub app.
+var RenovateProfile = BotProfile{
+	Name:         "renovate",
+	Username:     RenovateUsername,
+	UserType:     UserTypeBot,
+	BranchPrefix: "renovate/",
+	ParseTitle:   regexpTitleParser(renovateTitleRegexp),
+}
+
+// BuiltinBotProfiles returns the profiles of the bots that treebot supports
+// without any configuration.
+func BuiltinBotProfiles() []BotProfile {
+	return []BotProfile{DependabotProfile, RenovateProfile}
+}
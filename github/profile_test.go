@@ -0,0 +1,78 @@
+package github
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegexpMetadataParser(t *testing.T) {
+	parse, err := NewRegexpMetadataParser(`(?m)^dep: (?P<name>\S+) (?P<from>\S+) -> (?P<to>\S+) \((?P<type>\w+)\)$`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	message := "Update deps\n\ndep: a 1.0.0 -> 1.1.0 (minor)\ndep: b 2.0.0 -> 3.0.0 (Major)\ndep: c 1.0 -> 1.1 (digest)\n"
+	expected := []DependencyUpdate{
+		{Name: "a", From: "1.0.0", To: "1.1.0", Type: UpdateTypeMinor},
+		{Name: "b", From: "2.0.0", To: "3.0.0", Type: UpdateTypeMajor},
+		{Name: "c", From: "1.0", To: "1.1"},
+	}
+	if actual := parse(message); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got %+v, expected %+v", actual, expected)
+	}
+	if actual := parse("Update deps"); actual != nil {
+		t.Errorf("expected no updates, but got %+v", actual)
+	}
+
+	for _, pattern := range []string{`(`, `dep: \S+`} {
+		if _, err := NewRegexpMetadataParser(pattern); err == nil {
+			t.Errorf("'%s': expected an error", pattern)
+		}
+	}
+}
+
+func TestUpdatesCompleteWithTruncatedBody(t *testing.T) {
+	title := "Bump the go group with 3 updates"
+	// Dependabot truncates long descriptions, so the body of a grouped update
+	// can list fewer updates than the title announces.
+	body := "Bumps the go group with 3 updates: [golang.org/x/net](https://github.com/golang/net), [golang.org/x/sys](https://github.com/golang/sys) and [golang.org/x/text](https://github.com/golang/text).\n\n" +
+		"Updates `golang.org/x/net` from 0.17.0 to 0.19.0\n- [Commits](https://github.com/golang/net/compare/v0.17.0...v0.19.0)\n\n" +
+		"Updates `golang.org/x/sys` from 0.14.0 to 0.15.0\n- [Commits](https://github.com/golang/sys/compare/v0.14.0...v0.15.0)\n\n" +
+		"Updates `golang.org/x/text` from 0.1"
+	message := "Bump the go group with 3 updates\n\n" +
+		"---\n" +
+		"updated-dependencies:\n" +
+		"- dependency-name: golang.org/x/net\n" +
+		"  update-type: version-update:semver-minor\n" +
+		"- dependency-name: golang.org/x/sys\n" +
+		"  update-type: version-update:semver-minor\n" +
+		"- dependency-name: golang.org/x/text\n" +
+		"  update-type: version-update:semver-major\n" +
+		"...\n"
+
+	p := DependabotProfile
+	if count := p.UpdateCount(title); count != 3 {
+		t.Fatalf("expected the title to announce 3 updates, but got %d", count)
+	}
+
+	updates := MergeDependencyUpdates(p.UpdatesFromTitle(title), p.UpdatesFromBody(body))
+	if len(updates) != 2 {
+		t.Fatalf("expected the truncated body to list 2 updates, but got %+v", updates)
+	}
+	if p.UpdatesComplete(title, updates) {
+		t.Errorf("expected the updates from the truncated body to be incomplete")
+	}
+
+	updates = MergeDependencyUpdates(updates, p.UpdatesFromMetadata(message))
+	expected := []DependencyUpdate{
+		{Name: "golang.org/x/net", From: "0.17.0", To: "0.19.0", Type: UpdateTypeMinor},
+		{Name: "golang.org/x/sys", From: "0.14.0", To: "0.15.0", Type: UpdateTypeMinor},
+		{Name: "golang.org/x/text", Type: UpdateTypeMajor},
+	}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("got %+v, expected %+v", updates, expected)
+	}
+	if !p.UpdatesComplete(title, updates) {
+		t.Errorf("expected the updates to be complete once the commit metadata is merged")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/google/go-github/v40/github"
 	"github.com/pkg/errors"
@@ -18,9 +19,26 @@ const (
 	MergeableStateClean    = "clean"
 	MergeableStateUnstable = "unstable"
 	MergeableStateDirty    = "dirty"
+	MergeableStateBehind   = "behind"
 )
 
+// GetPRFromNotification gets the PR that the notification refers to. PRs are
+// only fetched once per run; use RefreshPRFromNotification to get the latest
+// state of a PR.
 func (c *Client) GetPRFromNotification(ctx context.Context, n github.Notification) (*github.PullRequest, error) {
+	c.prsMu.Lock()
+	pr, ok := c.prs[n.Subject.GetURL()]
+	c.prsMu.Unlock()
+	if ok {
+		return &pr, nil
+	}
+
+	return c.RefreshPRFromNotification(ctx, n)
+}
+
+// RefreshPRFromNotification fetches the latest state of the PR that the
+// notification refers to.
+func (c *Client) RefreshPRFromNotification(ctx context.Context, n github.Notification) (*github.PullRequest, error) {
 	req, err := c.NewRequest(http.MethodGet, n.Subject.GetURL(), nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "creating request")
@@ -36,26 +54,52 @@ func (c *Client) GetPRFromNotification(ctx context.Context, n github.Notificatio
 		return nil, errors.Wrap(err, "GitHub response")
 	}
 
+	c.prsMu.Lock()
+	c.prs[n.Subject.GetURL()] = pr
+	c.prsMu.Unlock()
+
 	return &pr, nil
 }
 
-func (c *Client) UpdatePRFromNotification(ctx context.Context, n PullRequestNotification) error {
+// UpdateBranchOutcome is the result of asking GitHub to update a PR's branch
+// with the changes from its base branch.
+type UpdateBranchOutcome string
+
+const (
+	// UpdateBranchAccepted means that GitHub will update the branch.
+	UpdateBranchAccepted UpdateBranchOutcome = "accepted"
+	// UpdateBranchUpToDate means that the branch already has all the changes
+	// from the base branch.
+	UpdateBranchUpToDate UpdateBranchOutcome = "already-up-to-date"
+	// UpdateBranchConflict means that the base branch can't be merged into
+	// the branch because they conflict.
+	UpdateBranchConflict UpdateBranchOutcome = "conflict"
+	// UpdateBranchPermission means that the user isn't allowed to update the
+	// branch.
+	UpdateBranchPermission UpdateBranchOutcome = "permission"
+	// UpdateBranchNetwork means that GitHub couldn't be reached or failed to
+	// handle the request, so it may succeed if retried.
+	UpdateBranchNetwork UpdateBranchOutcome = "network"
+	// UpdateBranchRejected means that GitHub rejected the request for some
+	// other reason.
+	UpdateBranchRejected UpdateBranchOutcome = "rejected"
+)
+
+// UpdatePRFromNotification asks GitHub to update the PR's branch with the
+// changes from its base branch. The error is nil only if the update was
+// accepted.
+func (c *Client) UpdatePRFromNotification(ctx context.Context, n PullRequestNotification) (UpdateBranchOutcome, error) {
 	owner := n.Notification.Repository.Owner.GetLogin()
 	repo := n.Notification.Repository.GetName()
 	prNum := n.PullRequest.GetNumber()
 
 	res, resp, err := c.PullRequests.UpdateBranch(ctx, owner, repo, prNum, nil)
-	// GitHub returns 202 Accepted to indicate a background job will handle the
-	// branch update, which manifests as an error even though the request was
-	// successfully submitted.
 	if resp != nil {
 		defer resp.Body.Close()
 	}
-	if resp.StatusCode == http.StatusAccepted {
-		return nil
-	}
-	if err != nil {
-		return errors.Wrap(err, "updating branch")
+	outcome := classifyUpdateBranch(resp, err)
+	if outcome != UpdateBranchAccepted {
+		return outcome, errors.Wrapf(err, "updating branch (%s)", outcome)
 	}
 
 	zap.S().Debugw("updated PR successfully",
@@ -63,25 +107,62 @@ func (c *Client) UpdatePRFromNotification(ctx context.Context, n PullRequestNoti
 		"url", res.GetURL(),
 	)
 
-	return nil
+	return outcome, nil
 }
 
-func (c *Client) MergePRFromNotification(ctx context.Context, n PullRequestNotification) error {
+func classifyUpdateBranch(resp *github.Response, err error) UpdateBranchOutcome {
+	if resp == nil {
+		return UpdateBranchNetwork
+	}
+
+	switch code := resp.StatusCode; {
+	// GitHub returns 202 Accepted to indicate a background job will handle
+	// the branch update, which manifests as an error even though the request
+	// was successfully submitted.
+	case code == http.StatusAccepted, err == nil:
+		return UpdateBranchAccepted
+	case code == http.StatusUnauthorized, code == http.StatusForbidden, code == http.StatusNotFound:
+		return UpdateBranchPermission
+	case code == http.StatusUnprocessableEntity:
+		var msg string
+		if errResp, ok := err.(*github.ErrorResponse); ok {
+			msg = strings.ToLower(errResp.Message)
+		}
+		switch {
+		case strings.Contains(msg, "no new commits"), strings.Contains(msg, "up to date"), strings.Contains(msg, "up-to-date"):
+			return UpdateBranchUpToDate
+		case strings.Contains(msg, "conflict"):
+			return UpdateBranchConflict
+		}
+		return UpdateBranchRejected
+	case code >= http.StatusInternalServerError:
+		return UpdateBranchNetwork
+	default:
+		return UpdateBranchRejected
+	}
+}
+
+// MergePRFromNotification squash merges the PR and returns the SHA of the
+// resulting commit on the base branch. The PR is only merged if its head
+// commit is still the one in the notification's PR, so that commits pushed
+// after the PR was checked aren't merged.
+func (c *Client) MergePRFromNotification(ctx context.Context, n PullRequestNotification) (string, error) {
 	owner := n.Notification.Repository.Owner.GetLogin()
 	repo := n.Notification.Repository.GetName()
 	prNum := n.PullRequest.GetNumber()
 	opts := github.PullRequestOptions{
+		SHA:                n.PullRequest.GetHead().GetSHA(),
 		MergeMethod:        "squash",
 		DontDefaultIfBlank: true,
 	}
 
 	res, resp, err := c.PullRequests.Merge(ctx, owner, repo, prNum, "", &opts)
 	if err != nil {
-		return errors.Wrap(err, "merging PR")
+		return "", errors.Wrap(err, "merging PR")
 	}
 	defer resp.Body.Close()
 	if !res.GetMerged() {
-		return errors.New("PR was not merged")
+		return "", errors.New("PR was not merged")
 	}
 
 	zap.S().Debugw("merged PR successfully",
@@ -89,6 +170,92 @@ func (c *Client) MergePRFromNotification(ctx context.Context, n PullRequestNotif
 		"sha", res.GetSHA(),
 	)
 
+	return res.GetSHA(), nil
+}
+
+// ListOpenPRsByUser lists the open PRs in the repo against the base branch
+// that were opened by the user.
+func (c *Client) ListOpenPRsByUser(ctx context.Context, owner, repo, base, user string) ([]*github.PullRequest, error) {
+	opts := &github.PullRequestListOptions{
+		State:       PRStateOpen,
+		Base:        base,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var prs []*github.PullRequest
+	for {
+		page, resp, err := c.PullRequests.List(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing PRs")
+		}
+		resp.Body.Close()
+
+		for _, pr := range page {
+			if pr.GetUser().GetLogin() == user {
+				prs = append(prs, pr)
+			}
+		}
+		if resp.NextPage == 0 {
+			return prs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// FindOpenPRByBranch finds the open PR in the repo whose head is the branch in
+// the same repo. It returns nil if there is none.
+func (c *Client) FindOpenPRByBranch(ctx context.Context, owner, repo, branch string) (*github.PullRequest, error) {
+	prs, resp, err := c.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State: PRStateOpen,
+		Head:  owner + ":" + branch,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing PRs")
+	}
+	defer resp.Body.Close()
+
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return prs[0], nil
+}
+
+// GetPR gets the PR in the repo.
+func (c *Client) GetPR(ctx context.Context, owner, repo string, number int) (*github.PullRequest, error) {
+	pr, resp, err := c.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting PR")
+	}
+	defer resp.Body.Close()
+
+	return pr, nil
+}
+
+// CreatePR opens a PR in the repo to merge the head branch into the base
+// branch.
+func (c *Client) CreatePR(ctx context.Context, owner, repo string, pr github.NewPullRequest) (*github.PullRequest, error) {
+	created, resp, err := c.PullRequests.Create(ctx, owner, repo, &pr)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating PR")
+	}
+	defer resp.Body.Close()
+
+	return created, nil
+}
+
+// ClosePR comments on the PR in the repo and closes it.
+func (c *Client) ClosePR(ctx context.Context, owner, repo string, number int, comment string) error {
+	_, resp, err := c.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{Body: github.String(comment)})
+	if err != nil {
+		return errors.Wrap(err, "commenting on PR")
+	}
+	resp.Body.Close()
+
+	_, resp, err = c.PullRequests.Edit(ctx, owner, repo, number, &github.PullRequest{State: github.String(PRStateClosed)})
+	if err != nil {
+		return errors.Wrap(err, "closing PR")
+	}
+	defer resp.Body.Close()
+
 	return nil
 }
 
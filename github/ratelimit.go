@@ -0,0 +1,429 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// GitHub API rate limit resources.
+const (
+	RateLimitResourceCore    = "core"
+	RateLimitResourceGraphQL = "graphql"
+	RateLimitResourceSearch  = "search"
+)
+
+const (
+	headerRateLimitLimit     = "X-RateLimit-Limit"
+	headerRateLimitRemaining = "X-RateLimit-Remaining"
+	headerRateLimitReset     = "X-RateLimit-Reset"
+	headerRateLimitResource  = "X-RateLimit-Resource"
+	headerRetryAfter         = "Retry-After"
+)
+
+// RateLimit is the most recently observed rate limit for a GitHub API
+// resource.
+type RateLimit struct {
+	Resource  string
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// RateLimitOptions configure how the client reacts to the GitHub API rate
+// limits. Zero values are replaced by defaults.
+type RateLimitOptions struct {
+	// PauseBelow is the remaining request budget at or below which requests
+	// are paused until the rate limit resets.
+	PauseBelow int
+	// SlowDownBelow is the remaining request budget below which requests are
+	// spaced out evenly over the time left until the rate limit resets.
+	SlowDownBelow int
+	// MaxRetries is the maximum number of times a single request is retried.
+	MaxRetries int
+	// MinBackoff is the initial delay before retrying a failed request.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between retries of a failed request.
+	MaxBackoff time.Duration
+	// MaxWait is the longest the client will wait for a rate limit to reset
+	// before giving up on a request and returning the rate limited response.
+	// The deadline of the request's context also limits the wait, so a
+	// request never waits past it either. The default matches the timeouts
+	// that callers put on their requests.
+	MaxWait time.Duration
+}
+
+func (o RateLimitOptions) withDefaults() RateLimitOptions {
+	if o.PauseBelow <= 0 {
+		o.PauseBelow = 10
+	}
+	if o.SlowDownBelow <= 0 {
+		o.SlowDownBelow = 200
+	}
+	if o.MaxRetries <= 0 {
+		o.MaxRetries = 5
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = time.Minute
+	}
+	if o.MaxWait <= 0 {
+		o.MaxWait = time.Minute
+	}
+	return o
+}
+
+// rateLimitTransport is an http.RoundTripper that tracks the GitHub API rate
+// limits from response headers. It slows down or pauses requests when the
+// remaining budget is low, waits out primary and secondary rate limits and
+// retries idempotent requests that fail transiently with jittered exponential
+// backoff.
+type rateLimitTransport struct {
+	base http.RoundTripper
+	opts RateLimitOptions
+
+	mu     sync.Mutex
+	limits map[string]RateLimit
+	// blockedUntil is the time before which no requests should be sent
+	// because a secondary rate limit was hit.
+	blockedUntil time.Time
+	rand         *rand.Rand
+}
+
+func newRateLimitTransport(base http.RoundTripper, opts RateLimitOptions) *rateLimitTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &rateLimitTransport{
+		base:   base,
+		opts:   opts.withDefaults(),
+		limits: map[string]RateLimit{},
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	resource := rateLimitResourceForRequest(req)
+
+	for attempt := 0; ; attempt++ {
+		if err := sleepContext(ctx, t.waitBeforeRequest(resource)); err != nil {
+			return nil, err
+		}
+
+		attemptReq, err := rewindRequest(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil {
+			if !isIdempotent(req.Method) || attempt >= t.opts.MaxRetries || ctx.Err() != nil {
+				return nil, err
+			}
+			backoff := t.backoff(attempt)
+			zap.S().Debugw("retrying GitHub request after error",
+				"method", req.Method,
+				"url", req.URL.String(),
+				"attempt", attempt+1,
+				"backoff", backoff,
+				"error", err,
+			)
+			if err := sleepContext(ctx, backoff); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		t.update(resp)
+
+		wait, limited, err := t.rateLimitedWait(resp, attempt)
+		if err != nil {
+			return nil, err
+		}
+		if limited {
+			// The request was rejected without being processed, so it's safe
+			// to retry regardless of the method.
+			if attempt >= t.opts.MaxRetries || wait > t.opts.MaxWait || pastDeadline(ctx, wait) {
+				return resp, nil
+			}
+			drainAndClose(resp)
+			zap.S().Warnw("hit GitHub rate limit, waiting before retrying",
+				"method", req.Method,
+				"url", req.URL.String(),
+				"attempt", attempt+1,
+				"wait", wait,
+			)
+			if err := sleepContext(ctx, wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode >= http.StatusInternalServerError && isIdempotent(req.Method) && attempt < t.opts.MaxRetries {
+			drainAndClose(resp)
+			backoff := t.backoff(attempt)
+			zap.S().Debugw("retrying GitHub request after server error",
+				"method", req.Method,
+				"url", req.URL.String(),
+				"status", resp.StatusCode,
+				"attempt", attempt+1,
+				"backoff", backoff,
+			)
+			if err := sleepContext(ctx, backoff); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return resp, nil
+	}
+}
+
+// waitBeforeRequest returns how long to wait before sending a request for the
+// given rate limit resource.
+func (t *rateLimitTransport) waitBeforeRequest(resource string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	if t.blockedUntil.After(now) {
+		wait = t.blockedUntil.Sub(now)
+	}
+
+	limit, ok := t.limits[resource]
+	if !ok || !limit.Reset.After(now) {
+		return wait
+	}
+
+	untilReset := limit.Reset.Sub(now)
+	var limitWait time.Duration
+	switch {
+	case limit.Remaining <= t.opts.PauseBelow:
+		limitWait = untilReset
+		zap.S().Warnw("GitHub rate limit budget is nearly exhausted, pausing until it resets",
+			"resource", resource,
+			"remaining", limit.Remaining,
+			"reset", limit.Reset,
+		)
+	case limit.Remaining < t.opts.SlowDownBelow:
+		limitWait = untilReset / time.Duration(limit.Remaining)
+	}
+	if limitWait > t.opts.MaxWait {
+		limitWait = t.opts.MaxWait
+	}
+	if limitWait > wait {
+		wait = limitWait
+	}
+
+	return wait
+}
+
+// update records the rate limit information from the response headers.
+func (t *rateLimitTransport) update(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get(headerRateLimitRemaining))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(resp.Header.Get(headerRateLimitLimit))
+	var reset time.Time
+	if secs, err := strconv.ParseInt(resp.Header.Get(headerRateLimitReset), 10, 64); err == nil {
+		reset = time.Unix(secs, 0)
+	}
+	resource := resp.Header.Get(headerRateLimitResource)
+	if resource == "" {
+		resource = rateLimitResourceForRequest(resp.Request)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.limits[resource] = RateLimit{
+		Resource:  resource,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     reset,
+	}
+}
+
+// rateLimitedWait checks if the response indicates that the request was
+// rejected due to a primary or secondary rate limit. If so, it returns how
+// long to wait before retrying.
+func (t *rateLimitTransport) rateLimitedWait(resp *http.Response, attempt int) (time.Duration, bool, error) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false, nil
+	}
+
+	if retryAfter := resp.Header.Get(headerRetryAfter); retryAfter != "" {
+		secs, err := strconv.Atoi(retryAfter)
+		if err == nil {
+			wait := time.Duration(secs) * time.Second
+			t.block(wait)
+			return wait, true, nil
+		}
+	}
+
+	if resp.Header.Get(headerRateLimitRemaining) == "0" {
+		if secs, err := strconv.ParseInt(resp.Header.Get(headerRateLimitReset), 10, 64); err == nil {
+			// Add a small buffer to account for clock skew.
+			return time.Until(time.Unix(secs, 0)) + time.Second, true, nil
+		}
+	}
+
+	// Secondary rate limits don't always come with a Retry-After header, so
+	// check the error message.
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, false, errors.Wrap(err, "reading response body")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	msg := strings.ToLower(string(body))
+	if strings.Contains(msg, "secondary rate limit") || strings.Contains(msg, "abuse") {
+		// GitHub recommends waiting at least a minute when there's no
+		// Retry-After header.
+		wait := t.backoff(attempt)
+		if wait < time.Minute {
+			wait = time.Minute
+		}
+		t.block(wait)
+		return wait, true, nil
+	}
+
+	return 0, false, nil
+}
+
+// block prevents any requests from being sent for the given duration.
+func (t *rateLimitTransport) block(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(t.blockedUntil) {
+		t.blockedUntil = until
+	}
+}
+
+// backoff returns the jittered exponential backoff delay for the given retry
+// attempt.
+func (t *rateLimitTransport) backoff(attempt int) time.Duration {
+	d := t.opts.MinBackoff << uint(attempt)
+	if d <= 0 || d > t.opts.MaxBackoff {
+		d = t.opts.MaxBackoff
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return d/2 + time.Duration(t.rand.Int63n(int64(d/2)+1))
+}
+
+// rateLimits returns the most recently observed rate limit for each resource.
+func (t *rateLimitTransport) rateLimits() []RateLimit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limits := make([]RateLimit, 0, len(t.limits))
+	for _, l := range t.limits {
+		limits = append(limits, l)
+	}
+	sort.Slice(limits, func(i, j int) bool {
+		return limits[i].Resource < limits[j].Resource
+	})
+
+	return limits
+}
+
+// RateLimits returns the most recently observed GitHub API rate limit for each
+// resource that the client has used.
+func (c *Client) RateLimits() []RateLimit {
+	if c.rateLimiter == nil {
+		return nil
+	}
+	return c.rateLimiter.rateLimits()
+}
+
+func rateLimitResourceForRequest(req *http.Request) string {
+	if req == nil || req.URL == nil {
+		return RateLimitResourceCore
+	}
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/graphql"):
+		return RateLimitResourceGraphQL
+	case strings.Contains(req.URL.Path, "/search/"):
+		return RateLimitResourceSearch
+	default:
+		return RateLimitResourceCore
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// rewindRequest returns the request to send for the given attempt. Retries
+// need a fresh copy of the request body.
+func rewindRequest(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 0 || req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("cannot retry request because its body cannot be rewound")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, errors.Wrap(err, "rewinding request body")
+	}
+	retry := req.Clone(req.Context())
+	retry.Body = body
+
+	return retry, nil
+}
+
+func drainAndClose(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// pastDeadline returns whether waiting for the given duration would outlast
+// the context's deadline.
+func pastDeadline(ctx context.Context, wait time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Now().Add(wait).After(deadline)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,276 @@
+package github
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedResponse is a response that a scriptedServer sends.
+type scriptedResponse struct {
+	status int
+	header map[string]string
+	body   string
+}
+
+// scriptedServer sends its responses in order, repeating the last one once
+// they run out, and records the body of every request it receives.
+type scriptedServer struct {
+	responses []scriptedResponse
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (s *scriptedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.bodies = append(s.bodies, string(body))
+	resp := s.responses[len(s.responses)-1]
+	if len(s.bodies) <= len(s.responses) {
+		resp = s.responses[len(s.bodies)-1]
+	}
+	s.mu.Unlock()
+
+	for k, v := range resp.header {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(resp.status)
+	w.Write([]byte(resp.body))
+}
+
+func (s *scriptedServer) requestBodies() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func TestRateLimitedWait(t *testing.T) {
+	reset := time.Now().Add(2 * time.Minute)
+
+	for name, tc := range map[string]struct {
+		response scriptedResponse
+		limited  bool
+		minWait  time.Duration
+		maxWait  time.Duration
+	}{
+		"RetryAfter": {
+			response: scriptedResponse{status: http.StatusForbidden, header: map[string]string{headerRetryAfter: "30"}},
+			limited:  true,
+			minWait:  30 * time.Second,
+			maxWait:  30 * time.Second,
+		},
+		"TooManyRequestsRetryAfter": {
+			response: scriptedResponse{status: http.StatusTooManyRequests, header: map[string]string{headerRetryAfter: "5"}},
+			limited:  true,
+			minWait:  5 * time.Second,
+			maxWait:  5 * time.Second,
+		},
+		"RemainingZero": {
+			response: scriptedResponse{status: http.StatusForbidden, header: map[string]string{
+				headerRateLimitRemaining: "0",
+				headerRateLimitReset:     strconv.FormatInt(reset.Unix(), 10),
+			}},
+			limited: true,
+			minWait: time.Until(reset) - time.Second,
+			maxWait: time.Until(reset) + time.Second,
+		},
+		"SecondaryLimitBody": {
+			response: scriptedResponse{status: http.StatusForbidden, body: `{"message":"You have exceeded a secondary rate limit. Please wait a few minutes before you try again."}`},
+			limited:  true,
+			minWait:  time.Minute,
+			maxWait:  time.Minute,
+		},
+		"AbuseBody": {
+			response: scriptedResponse{status: http.StatusForbidden, body: `{"message":"You have triggered an abuse detection mechanism."}`},
+			limited:  true,
+			minWait:  time.Minute,
+			maxWait:  time.Minute,
+		},
+		"Forbidden": {
+			response: scriptedResponse{status: http.StatusForbidden, body: `{"message":"Resource not accessible by integration"}`},
+		},
+		"RemainingZeroWithoutReset": {
+			response: scriptedResponse{status: http.StatusForbidden, header: map[string]string{headerRateLimitRemaining: "0"}},
+		},
+		"OK": {
+			response: scriptedResponse{status: http.StatusOK, header: map[string]string{headerRetryAfter: "30"}},
+		},
+	} {
+		ts := httptest.NewServer(&scriptedServer{responses: []scriptedResponse{tc.response}})
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			ts.Close()
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+
+		transport := newRateLimitTransport(nil, RateLimitOptions{MaxBackoff: time.Second})
+		wait, limited, err := transport.rateLimitedWait(resp, 0)
+		resp.Body.Close()
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		if limited != tc.limited {
+			t.Errorf("%s: got limited %t, expected %t", name, limited, tc.limited)
+			continue
+		}
+		if wait < tc.minWait || wait > tc.maxWait {
+			t.Errorf("%s: got wait %s, expected between %s and %s", name, wait, tc.minWait, tc.maxWait)
+		}
+	}
+}
+
+func TestRateLimitTransportRoundTrip(t *testing.T) {
+	retryNow := scriptedResponse{status: http.StatusForbidden, header: map[string]string{headerRetryAfter: "0"}}
+	ok := scriptedResponse{status: http.StatusOK, body: "ok"}
+
+	for name, tc := range map[string]struct {
+		method    string
+		body      string
+		timeout   time.Duration
+		responses []scriptedResponse
+		status    int
+		requests  int
+	}{
+		"RetriesAfterRetryAfter": {
+			method:    http.MethodGet,
+			responses: []scriptedResponse{retryNow, ok},
+			status:    http.StatusOK,
+			requests:  2,
+		},
+		"RetriesRateLimitedPostWithBody": {
+			method:    http.MethodPost,
+			body:      `{"title":"revert"}`,
+			responses: []scriptedResponse{retryNow, ok},
+			status:    http.StatusOK,
+			requests:  2,
+		},
+		"RetriesGetAfterServerError": {
+			method:    http.MethodGet,
+			responses: []scriptedResponse{{status: http.StatusBadGateway}, ok},
+			status:    http.StatusOK,
+			requests:  2,
+		},
+		"DoesNotRetryPostAfterServerError": {
+			method:    http.MethodPost,
+			body:      `{"title":"revert"}`,
+			responses: []scriptedResponse{{status: http.StatusBadGateway}, ok},
+			status:    http.StatusBadGateway,
+			requests:  1,
+		},
+		"DoesNotRetryPutAfterServerError": {
+			method:    http.MethodPut,
+			responses: []scriptedResponse{{status: http.StatusInternalServerError}, ok},
+			status:    http.StatusInternalServerError,
+			requests:  1,
+		},
+		"GivesUpAfterMaxRetries": {
+			method:    http.MethodGet,
+			responses: []scriptedResponse{{status: http.StatusServiceUnavailable}},
+			status:    http.StatusServiceUnavailable,
+			requests:  3,
+		},
+		"GivesUpWhenWaitExceedsMaxWait": {
+			method:    http.MethodGet,
+			responses: []scriptedResponse{{status: http.StatusForbidden, header: map[string]string{headerRetryAfter: "3600"}}, ok},
+			status:    http.StatusForbidden,
+			requests:  1,
+		},
+		"GivesUpWhenWaitExceedsDeadline": {
+			method:    http.MethodGet,
+			timeout:   time.Second,
+			responses: []scriptedResponse{{status: http.StatusForbidden, body: "You have exceeded a secondary rate limit"}, ok},
+			status:    http.StatusForbidden,
+			requests:  1,
+		},
+	} {
+		server := &scriptedServer{responses: tc.responses}
+		ts := httptest.NewServer(server)
+		transport := newRateLimitTransport(nil, RateLimitOptions{
+			MaxRetries: 2,
+			MinBackoff: time.Millisecond,
+			MaxBackoff: time.Millisecond,
+		})
+
+		ctx := context.Background()
+		if tc.timeout != 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+			defer cancel()
+		}
+		req, err := http.NewRequestWithContext(ctx, tc.method, ts.URL+"/repos/o/r/pulls", strings.NewReader(tc.body))
+		if err != nil {
+			ts.Close()
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+
+		start := time.Now()
+		resp, err := transport.RoundTrip(req)
+		elapsed := time.Since(start)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: got status %d, expected %d", name, resp.StatusCode, tc.status)
+		}
+		bodies := server.requestBodies()
+		if len(bodies) != tc.requests {
+			t.Errorf("%s: got %d requests, expected %d", name, len(bodies), tc.requests)
+		}
+		for i, body := range bodies {
+			if body != tc.body {
+				t.Errorf("%s: request %d had body '%s', expected '%s'", name, i+1, body, tc.body)
+			}
+		}
+		if elapsed > 5*time.Second {
+			t.Errorf("%s: took %s, expected not to wait out the rate limit", name, elapsed)
+		}
+	}
+}
+
+func TestRateLimitTransportTracksLimits(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	ts := httptest.NewServer(&scriptedServer{responses: []scriptedResponse{{
+		status: http.StatusOK,
+		header: map[string]string{
+			headerRateLimitLimit:     "5000",
+			headerRateLimitRemaining: "4321",
+			headerRateLimitReset:     strconv.FormatInt(reset.Unix(), 10),
+			headerRateLimitResource:  RateLimitResourceSearch,
+		},
+	}}})
+	defer ts.Close()
+
+	transport := newRateLimitTransport(nil, RateLimitOptions{})
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/search/issues", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+
+	limits := transport.rateLimits()
+	expected := RateLimit{Resource: RateLimitResourceSearch, Limit: 5000, Remaining: 4321, Reset: reset}
+	if len(limits) != 1 || limits[0] != expected {
+		t.Errorf("got %+v, expected [%+v]", limits, expected)
+	}
+	if wait := transport.waitBeforeRequest(RateLimitResourceSearch); wait != 0 {
+		t.Errorf("expected no wait with a large budget, but got %s", wait)
+	}
+}
@@ -2,12 +2,22 @@ package github
 
 import (
 	"context"
-	"net/http"
+	"time"
 
 	"github.com/google/go-github/v40/github"
 	"github.com/pkg/errors"
 )
 
+// Repo permission levels for a user.
+const (
+	PermissionAdmin    = "admin"
+	PermissionMaintain = "maintain"
+	PermissionWrite    = "write"
+	PermissionTriage   = "triage"
+	PermissionRead     = "read"
+	PermissionNone     = "none"
+)
+
 const (
 	CommitStatusPending = "pending"
 	CommitStatusError   = "error"
@@ -19,45 +29,159 @@ const (
 	CombinedStatusFailure = "failure"
 )
 
+// GetCommitStatusesFromNotification gets the latest commit status for each
+// status context of the PR's head commit, using the PR's snapshot if it's
+// up-to-date.
 func (c *Client) GetCommitStatusesFromNotification(ctx context.Context, n PullRequestNotification) ([]github.RepoStatus, error) {
-	req, err := c.NewRequest(http.MethodGet, n.PullRequest.GetStatusesURL(), nil)
+	if n.Snapshot != nil && n.Snapshot.HeadSHA == n.PullRequest.GetHead().GetSHA() {
+		return n.Snapshot.Statuses, nil
+	}
+
+	// Unlike the PR's statuses URL, which lists every status that has been
+	// set on the commit, the combined status only has the latest status for
+	// each context, which matches the snapshot.
+	combined, err := c.GetCombinedStatusFromNotificationAndCommit(ctx, n.Notification, n.PullRequest.GetHead().GetSHA())
 	if err != nil {
-		return nil, errors.Wrap(err, "creating request")
+		return nil, errors.Wrap(err, "getting combined status of head commit")
 	}
 
-	statuses := []github.RepoStatus{}
-	resp, err := c.Do(ctx, req, &statuses)
+	statuses := make([]github.RepoStatus, 0, len(combined.Statuses))
+	for _, s := range combined.Statuses {
+		statuses = append(statuses, *s)
+	}
+	return statuses, nil
+}
+
+// GetCommitDate gets the time when the commit in the repo was committed.
+func (c *Client) GetCommitDate(ctx context.Context, owner, repo, sha string) (time.Time, error) {
+	commit, resp, err := c.Git.GetCommit(ctx, owner, repo, sha)
 	if err != nil {
-		return nil, errors.Wrap(err, "requesting repository status information")
+		return time.Time{}, errors.Wrap(err, "getting commit")
 	}
 	defer resp.Body.Close()
 
-	return statuses, nil
+	return commit.GetCommitter().GetDate(), nil
+}
+
+// GetCommitsFromNotification lists the commits on the PR, oldest first. At
+// most 250 commits are listed.
+func (c *Client) GetCommitsFromNotification(ctx context.Context, n PullRequestNotification) ([]*github.RepositoryCommit, error) {
+	owner := n.Notification.Repository.Owner.GetLogin()
+	repo := n.Notification.Repository.GetName()
+	prNum := n.PullRequest.GetNumber()
+
+	opts := &github.ListOptions{PerPage: 100}
+	var commits []*github.RepositoryCommit
+	for {
+		page, resp, err := c.PullRequests.ListCommits(ctx, owner, repo, prNum, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "requesting commit information")
+		}
+		resp.Body.Close()
+
+		commits = append(commits, page...)
+		if resp.NextPage == 0 {
+			return commits, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (c *Client) GetCombinedStatusFromNotificationAndCommit(ctx context.Context, n github.Notification, sha string) (*github.CombinedStatus, error) {
+	owner := n.Repository.Owner.GetLogin()
+	repo := n.Repository.GetName()
+
+	res, resp, err := c.Repositories.GetCombinedStatus(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting commit status information")
+	}
+	defer resp.Body.Close()
+
+	return res, nil
 }
 
-func (c *Client) GetCommitsFromNotification(ctx context.Context, n PullRequestNotification) ([]github.Commit, error) {
-	req, err := c.NewRequest(http.MethodGet, n.PullRequest.GetCommitsURL(), nil)
+// GetCombinedStatusFromNotification gets the combined status of the PR's head
+// commit, using the PR's snapshot if it's up-to-date.
+func (c *Client) GetCombinedStatusFromNotification(ctx context.Context, n PullRequestNotification) (*github.CombinedStatus, error) {
+	if n.Snapshot != nil && n.Snapshot.HeadSHA == n.PullRequest.GetHead().GetSHA() {
+		statuses := make([]*github.RepoStatus, 0, len(n.Snapshot.Statuses))
+		for i := range n.Snapshot.Statuses {
+			statuses = append(statuses, &n.Snapshot.Statuses[i])
+		}
+		return &github.CombinedStatus{
+			State:      github.String(CombinedState(n.Snapshot.Statuses)),
+			SHA:        github.String(n.Snapshot.HeadSHA),
+			TotalCount: github.Int(len(statuses)),
+			Statuses:   statuses,
+		}, nil
+	}
+
+	commits, err := c.GetCommitsFromNotification(ctx, n)
 	if err != nil {
-		return nil, errors.Wrap(err, "creating request")
+		return nil, errors.Wrap(err, "getting commits from notification")
+	}
+	if len(commits) == 0 {
+		return &github.CombinedStatus{State: github.String(CombinedStatusPending)}, nil
+	}
+
+	return c.GetCombinedStatusFromNotificationAndCommit(ctx, n.Notification, commits[len(commits)-1].GetSHA())
+}
+
+// CombinedState computes the combined state of the latest statuses in the same
+// way as GitHub does for the combined status of a commit.
+func CombinedState(statuses []github.RepoStatus) string {
+	if len(statuses) == 0 {
+		return CombinedStatusPending
 	}
 
-	commits := []github.Commit{}
-	resp, err := c.Do(ctx, req, &commits)
+	state := CombinedStatusSuccess
+	for _, s := range statuses {
+		switch s.GetState() {
+		case CommitStatusError, CommitStatusFailure:
+			return CombinedStatusFailure
+		case CommitStatusPending:
+			state = CombinedStatusPending
+		}
+	}
+
+	return state
+}
+
+// GetUserPermission gets the user's permission level in the notification's
+// repo.
+func (c *Client) GetUserPermission(ctx context.Context, n github.Notification, user string) (string, error) {
+	owner := n.Repository.Owner.GetLogin()
+	repo := n.Repository.GetName()
+
+	res, resp, err := c.Repositories.GetPermissionLevel(ctx, owner, repo, user)
 	if err != nil {
-		return nil, errors.Wrap(err, "requesting commit information")
+		return "", errors.Wrap(err, "requesting user permission level")
 	}
 	defer resp.Body.Close()
 
-	return commits, nil
+	return res.GetPermission(), nil
 }
 
-func (c *Client) GetCombinedStatusFromNotificationAndCommit(ctx context.Context, n github.Notification, commit github.Commit) (*github.CombinedStatus, error) {
+// HasWriteAccess returns whether the permission level allows pushing to the
+// repo.
+func HasWriteAccess(permission string) bool {
+	switch permission {
+	case PermissionAdmin, PermissionMaintain, PermissionWrite:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetBranchCombinedStatus gets the combined status of the head commit of the
+// branch in the notification's repo.
+func (c *Client) GetBranchCombinedStatus(ctx context.Context, n github.Notification, branch string) (*github.CombinedStatus, error) {
 	owner := n.Repository.Owner.GetLogin()
 	repo := n.Repository.GetName()
 
-	res, resp, err := c.Repositories.GetCombinedStatus(ctx, owner, repo, commit.GetSHA(), nil)
+	res, resp, err := c.Repositories.GetCombinedStatus(ctx, owner, repo, branch, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "requesting commit status information")
+		return nil, errors.Wrap(err, "requesting branch status information")
 	}
 	defer resp.Body.Close()
 
@@ -0,0 +1,244 @@
+package github
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v40/github"
+	"github.com/pkg/errors"
+)
+
+// ErrRevertConflict is returned when a commit can't be reverted cleanly
+// because files that it changed were changed again afterwards.
+var ErrRevertConflict = errors.New("files changed by the commit were modified by later commits")
+
+// ErrRevertTooLarge is returned when a commit can't be reverted safely
+// because GitHub may not list every file that it or later commits changed.
+var ErrRevertTooLarge = errors.New("too many files changed to list them all")
+
+// GetCommitCombinedStatus gets the combined status of the commit in the repo.
+func (c *Client) GetCommitCombinedStatus(ctx context.Context, owner, repo, sha string) (*github.CombinedStatus, error) {
+	res, resp, err := c.Repositories.GetCombinedStatus(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting commit status information")
+	}
+	defer resp.Body.Close()
+
+	return res, nil
+}
+
+// GetCommitParents gets the SHAs of the parents of the commit in the repo.
+func (c *Client) GetCommitParents(ctx context.Context, owner, repo, sha string) ([]string, error) {
+	commit, resp, err := c.Git.GetCommit(ctx, owner, repo, sha)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting commit")
+	}
+	defer resp.Body.Close()
+
+	var parents []string
+	for _, p := range commit.Parents {
+		parents = append(parents, p.GetSHA())
+	}
+	return parents, nil
+}
+
+// RevertOptions describe a revert PR to open.
+type RevertOptions struct {
+	Owner string
+	Repo  string
+	// SHA is the commit to revert. It must have exactly one parent.
+	SHA string
+	// Base is the branch that the commit is on and that the revert PR is
+	// opened against.
+	Base string
+	// Branch is the name of the branch for the revert commit. If there's
+	// already an open PR from it, that PR is returned instead of opening a new
+	// one; otherwise, the branch is reset to the revert commit.
+	Branch string
+	Title  string
+	Body   string
+}
+
+// CreateRevertPR creates a commit on top of the base branch that undoes the
+// changes of the commit and opens a PR for it. The revert commit is built
+// from the commit's parent tree, so it only works if none of the files that
+// the commit changed were changed again on the base branch; otherwise, it
+// returns ErrRevertConflict. If too many files changed to be sure of that, it
+// returns ErrRevertTooLarge.
+func (c *Client) CreateRevertPR(ctx context.Context, opts RevertOptions) (*github.PullRequest, error) {
+	existing, err := c.FindOpenPRByBranch(ctx, opts.Owner, opts.Repo, opts.Branch)
+	if err != nil {
+		return nil, errors.Wrap(err, "finding existing revert PR")
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	parents, err := c.GetCommitParents(ctx, opts.Owner, opts.Repo, opts.SHA)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting commit to revert")
+	}
+	if len(parents) != 1 {
+		return nil, errors.Errorf("commit to revert has %d parents, but only commits with a single parent can be reverted", len(parents))
+	}
+	parent := parents[0]
+
+	head, err := c.GetBranchHeadSHA(ctx, opts.Owner, opts.Repo, opts.Base)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting base branch")
+	}
+
+	changed, err := c.compareFiles(ctx, opts.Owner, opts.Repo, parent, opts.SHA)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting files changed by commit")
+	}
+	if head != opts.SHA {
+		since, err := c.compareFiles(ctx, opts.Owner, opts.Repo, opts.SHA, head)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting files changed since commit")
+		}
+		touched := map[string]bool{}
+		for _, f := range since {
+			touched[f.GetFilename()] = true
+			if prev := f.GetPreviousFilename(); prev != "" {
+				touched[prev] = true
+			}
+		}
+		for _, f := range changed {
+			if touched[f.GetFilename()] || touched[f.GetPreviousFilename()] {
+				return nil, ErrRevertConflict
+			}
+		}
+	}
+
+	parentCommit, resp, err := c.Git.GetCommit(ctx, opts.Owner, opts.Repo, parent)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting parent commit")
+	}
+	resp.Body.Close()
+	parentTree, resp, err := c.Git.GetTree(ctx, opts.Owner, opts.Repo, parentCommit.GetTree().GetSHA(), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting parent tree")
+	}
+	resp.Body.Close()
+	if parentTree.GetTruncated() {
+		return nil, errors.New("parent tree is too large to be listed")
+	}
+	parentEntries := map[string]*github.TreeEntry{}
+	for _, e := range parentTree.Entries {
+		parentEntries[e.GetPath()] = e
+	}
+
+	var entries []*github.TreeEntry
+	restore := func(path string) error {
+		e, ok := parentEntries[path]
+		if !ok {
+			return errors.Errorf("file '%s' does not exist in parent commit", path)
+		}
+		entries = append(entries, &github.TreeEntry{
+			Path: e.Path,
+			Mode: e.Mode,
+			Type: e.Type,
+			SHA:  e.SHA,
+		})
+		return nil
+	}
+	remove := func(path string) {
+		// An entry with neither a SHA nor content deletes the file.
+		entries = append(entries, &github.TreeEntry{
+			Path: github.String(path),
+			Mode: github.String("100644"),
+			Type: github.String("blob"),
+		})
+	}
+	for _, f := range changed {
+		switch f.GetStatus() {
+		case "added":
+			remove(f.GetFilename())
+		case "renamed":
+			remove(f.GetFilename())
+			if err := restore(f.GetPreviousFilename()); err != nil {
+				return nil, err
+			}
+		default:
+			if err := restore(f.GetFilename()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	headCommit, resp, err := c.Git.GetCommit(ctx, opts.Owner, opts.Repo, head)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting head commit of base branch")
+	}
+	resp.Body.Close()
+	tree, resp, err := c.Git.CreateTree(ctx, opts.Owner, opts.Repo, headCommit.GetTree().GetSHA(), entries)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating revert tree")
+	}
+	resp.Body.Close()
+
+	commit, resp, err := c.Git.CreateCommit(ctx, opts.Owner, opts.Repo, &github.Commit{
+		Message: github.String(fmt.Sprintf("%s\n\nThis reverts commit %s.", opts.Title, opts.SHA)),
+		Tree:    &github.Tree{SHA: tree.SHA},
+		Parents: []*github.Commit{{SHA: github.String(head)}},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "creating revert commit")
+	}
+	resp.Body.Close()
+
+	// The branch may be left over from an earlier attempt that failed before
+	// opening the PR.
+	if err := c.ResetBranch(ctx, opts.Owner, opts.Repo, opts.Branch, commit.GetSHA()); err != nil {
+		return nil, errors.Wrap(err, "creating revert branch")
+	}
+
+	pr, err := c.CreatePR(ctx, opts.Owner, opts.Repo, github.NewPullRequest{
+		Title: github.String(opts.Title),
+		Head:  github.String(opts.Branch),
+		Base:  github.String(opts.Base),
+		Body:  github.String(opts.Body),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "creating revert PR")
+	}
+
+	return pr, nil
+}
+
+// maxCompareFiles is the most files that GitHub lists when comparing two
+// commits. A comparison that lists this many files may be missing some.
+const maxCompareFiles = 300
+
+// compareFiles lists the files that changed between the two commits. If the
+// list may be incomplete, it returns ErrRevertTooLarge.
+func (c *Client) compareFiles(ctx context.Context, owner, repo, base, head string) ([]*github.CommitFile, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	seen := map[string]bool{}
+	var files []*github.CommitFile
+	for {
+		cmp, resp, err := c.Repositories.CompareCommits(ctx, owner, repo, base, head, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "comparing commits")
+		}
+		resp.Body.Close()
+
+		// The files may be repeated on every page of the comparison.
+		for _, f := range cmp.Files {
+			if !seen[f.GetFilename()] {
+				seen[f.GetFilename()] = true
+				files = append(files, f)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if len(files) >= maxCompareFiles {
+		return nil, ErrRevertTooLarge
+	}
+	return files, nil
+}
@@ -0,0 +1,146 @@
+package operations
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kimchelly/treebot-go/audit"
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	repoFlag  = "repo"
+	sinceFlag = "since"
+	untilFlag = "until"
+	jsonFlag  = "json"
+)
+
+func Audit() *cli.Command {
+	return &cli.Command{
+		Name:  "audit",
+		Usage: "query the audit log of PR authorizations and merges",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  auditLogFlag,
+				Usage: "path to the audit log",
+				Value: defaultAuditLog(),
+			},
+			&cli.StringSliceFlag{
+				Name:  repoFlag,
+				Usage: "only show entries for the given repo(s) in the form 'owner/repo'",
+			},
+			&cli.StringFlag{
+				Name:  sinceFlag,
+				Usage: "only show entries on or after the given date (YYYY-MM-DD) or time (RFC3339)",
+			},
+			&cli.StringFlag{
+				Name:  untilFlag,
+				Usage: "only show entries on or before the given date (YYYY-MM-DD) or before the given time (RFC3339)",
+			},
+			&cli.BoolFlag{
+				Name:  jsonFlag,
+				Usage: "output the matching entries as JSON lines",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			return queryAuditLog(c)
+		},
+	}
+}
+
+func queryAuditLog(c *cli.Context) error {
+	q := audit.Query{Repos: c.StringSlice(repoFlag)}
+	if since := c.String(sinceFlag); since != "" {
+		t, _, err := parseDateOrTime(since)
+		if err != nil {
+			return errors.Wrapf(err, "parsing flag '%s'", sinceFlag)
+		}
+		q.Since = t
+	}
+	if until := c.String(untilFlag); until != "" {
+		t, isDate, err := parseDateOrTime(until)
+		if err != nil {
+			return errors.Wrapf(err, "parsing flag '%s'", untilFlag)
+		}
+		if isDate {
+			// Include the entire day.
+			t = t.AddDate(0, 0, 1)
+		}
+		q.Until = t
+	}
+
+	entries, err := audit.Read(c.String(auditLogFlag), q)
+	if err != nil {
+		return errors.Wrap(err, "reading audit log")
+	}
+
+	if c.Bool(jsonFlag) {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return errors.Wrap(err, "writing audit entry")
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTOR\tACTION\tRESULT\tPR\tHEAD\tRULE\tINTERACTIVE\tUPDATES")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%t\t%s\n",
+			e.Time.Local().Format(time.RFC3339),
+			e.Actor,
+			e.Action,
+			e.Result,
+			e.URL,
+			shortSHA(e.HeadSHA),
+			e.Rule,
+			e.Interactive,
+			auditUpdates(e.Updates),
+		)
+	}
+
+	return errors.Wrap(w.Flush(), "writing audit entries")
+}
+
+// parseDateOrTime parses either a date (in the local time zone) or an RFC3339
+// timestamp. It returns whether the value was a date.
+func parseDateOrTime(s string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false, errors.Errorf("'%s' is neither a date (YYYY-MM-DD) nor a time (RFC3339)", s)
+	}
+	return t, false, nil
+}
+
+// auditUpdates describes the version changes of the dependency updates in an
+// audit entry, one for each member of a grouped update.
+func auditUpdates(updates []audit.Update) string {
+	var formatted []string
+	for _, u := range updates {
+		formatted = append(formatted, formatUpdate(github.DependencyUpdate{
+			Name:      u.Name,
+			From:      u.From,
+			To:        u.To,
+			Type:      u.Type,
+			Directory: u.Directory,
+		}))
+	}
+	return strings.Join(formatted, "; ")
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
@@ -5,27 +5,126 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
+	gogithub "github.com/google/go-github/v40/github"
 	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 )
 
 const (
-	pastFlag                = "past"
-	includeReadFlag         = "include-read"
-	includeTitlesFlag       = "include-titles"
-	includeReasonsFlag      = "include-reasons"
-	interactiveFlag         = "interactive"
-	checkDependabotUserFlag = "check-dependabot-user"
+	pastFlag                   = "past"
+	includeReadFlag            = "include-read"
+	includeTitlesFlag          = "include-titles"
+	includeReasonsFlag         = "include-reasons"
+	interactiveFlag            = "interactive"
+	checkDependabotUserFlag    = "check-dependabot-user"
+	parallelismFlag            = "parallelism"
+	httpCacheDirFlag           = "http-cache-dir"
+	graphQLFlag                = "graphql"
+	stateFileFlag              = "state-file"
+	maxAttemptsFlag            = "max-attempts"
+	auditLogFlag               = "audit-log"
+	chatOpsFlag                = "chatops"
+	holdLabelsFlag             = "hold-labels"
+	configFlag                 = "config"
+	authorizeInFreezeFlag      = "authorize-during-freeze"
+	maxMergesPerRunFlag        = "max-merges-per-run"
+	maxMergesPerRepoPerRunFlag = "max-merges-per-repo-per-run"
+	maxMergesPerRepoPerDayFlag = "max-merges-per-repo-per-day"
+	waitForMainlineFlag        = "wait-for-mainline"
+	mainlineTimeoutFlag        = "mainline-timeout"
+	markNotificationsFlag      = "mark-notifications"
+	unsubscribeFlag            = "unsubscribe-after-merge"
+	explainSkipsFlag           = "explain-skips"
+	blockedLabelFlag           = "blocked-label"
+	verifyMergesFlag           = "verify-merges"
+	revertFailedMergesFlag     = "revert-failed-merges"
+	revertHoldFlag             = "revert-hold"
+	verifyTimeoutFlag          = "verify-timeout"
+	requestRebaseFlag          = "request-rebase"
+	rebaseTimeoutFlag          = "rebase-timeout"
+	maxRebaseRequestsFlag      = "max-rebase-requests"
+	updateBehindFlag           = "update-behind"
+	retryCommentFlag           = "retry-comment"
+	trustedMergeAuthorsFlag    = "trusted-merge-authors"
+	botsFlag                   = "bots"
+	includePackagesFlag        = "include-packages"
+	includeDirectoriesFlag     = "include-directories"
+	maxUpdateTypeFlag          = "max-update-type"
 )
 
-func autoGitHubFlags() []cli.Flag {
+// sessionFlags returns the flags that every command that checks bot PRs
+// needs to set up its session.
+func sessionFlags() []cli.Flag {
 	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  interactiveFlag,
+			Usage: "authorize PRs in interactive session",
+		},
+		&cli.BoolFlag{
+			Name:  checkDependabotUserFlag,
+			Usage: fmt.Sprintf("do an extra check to ensure that the notification is from one of the bots in --%s", botsFlag),
+		},
+		&cli.StringFlag{
+			Name:  httpCacheDirFlag,
+			Usage: "directory for caching GitHub API responses across runs; entries unused for a week are removed (set to empty to disable caching)",
+			Value: defaultHTTPCacheDir(),
+		},
+		&cli.BoolFlag{
+			Name:  graphQLFlag,
+			Usage: "fetch PR state in bulk using the GraphQL API, falling back to the REST API if that fails",
+			Value: true,
+		},
+		&cli.StringFlag{
+			Name:  stateFileFlag,
+			Usage: "file where actions taken on PRs are remembered between runs (set to empty to only remember them for the current run)",
+			Value: defaultStateFile(),
+		},
+		&cli.StringFlag{
+			Name:  auditLogFlag,
+			Usage: "file where every authorization and merge is recorded (set to empty to disable the audit log)",
+			Value: defaultAuditLog(),
+		},
+		&cli.StringSliceFlag{
+			Name:  botsFlag,
+			Usage: "names of the bots whose PRs are acted on, either built-in (dependabot, renovate) or defined in the config file",
+			Value: cli.NewStringSlice(github.DependabotProfile.Name),
+		},
+		&cli.StringSliceFlag{
+			Name:  includePackagesFlag,
+			Usage: "only act on PRs that update these packages, as named in the PR title",
+		},
+		&cli.StringSliceFlag{
+			Name:  includeDirectoriesFlag,
+			Usage: "only act on PRs that update manifests in these directories, as named in the PR title (use / for the repo root)",
+		},
+		&cli.StringFlag{
+			Name:  maxUpdateTypeFlag,
+			Usage: fmt.Sprintf("only act on PRs whose version updates are no larger than this. Valid values: %s", strings.Join(github.UpdateTypes(), ", ")),
+		},
+		&cli.StringFlag{
+			Name:  configFlag,
+			Usage: "path to the YAML configuration file",
+		},
+		&cli.StringSliceFlag{
+			Name:  holdLabelsFlag,
+			Usage: "PRs with any of these labels are held and never acted on",
+			Value: cli.NewStringSlice("do-not-merge", "treebot:hold"),
+		},
+	}
+}
+
+// autoGitHubFlags returns the flags for the commands that act on bot PRs
+// found through notifications.
+func autoGitHubFlags() []cli.Flag {
+	return append(sessionFlags(),
 		&cli.BoolFlag{
 			Name:  includeReadFlag,
 			Usage: "include already-read notifications in Dependabot authorization checks",
@@ -43,15 +142,130 @@ func autoGitHubFlags() []cli.Flag {
 			Usage: "how long to search backwards in time for notifications",
 			Value: 24 * time.Hour,
 		},
+		&cli.IntFlag{
+			Name:  parallelismFlag,
+			Usage: "maximum number of repos whose PRs are processed concurrently (PRs in the same repo are always processed one at a time)",
+			Value: 4,
+		},
+		&cli.IntFlag{
+			Name:  maxAttemptsFlag,
+			Usage: "maximum number of failed attempts to act on a PR's head commit before giving up on it",
+			Value: 3,
+		},
+		&cli.StringFlag{
+			Name:  markNotificationsFlag,
+			Usage: fmt.Sprintf("mark notifications for merged or closed PRs as read or done so they don't show up again (note that read notifications are ignored unless --%s is set). Valid values: %s", includeReadFlag, strings.Join(markNotificationsModes(), ", ")),
+			Value: markNotificationsNone,
+		},
 		&cli.BoolFlag{
-			Name:  interactiveFlag,
-			Usage: "authorize PRs in interactive session",
+			Name:  unsubscribeFlag,
+			Usage: "unsubscribe from notifications for PRs after merging them",
 		},
 		&cli.BoolFlag{
-			Name:  checkDependabotUserFlag,
-			Usage: "do an extra check to ensure that the notification is from Dependabot",
+			Name:  explainSkipsFlag,
+			Usage: "label and comment on PRs that treebot can't act on to explain what blocks them (the label and comment are removed once the PR is unblocked)",
+		},
+		&cli.StringFlag{
+			Name:  blockedLabelFlag,
+			Usage: fmt.Sprintf("label to add to blocked PRs when --%s is set (set to empty to only comment)", explainSkipsFlag),
+			Value: "treebot:blocked",
+		},
+		&cli.BoolFlag{
+			Name:  requestRebaseFlag,
+			Usage: "ask Dependabot to rebase PRs that have merge conflicts, or to recreate them if rebasing doesn't help",
+		},
+		&cli.DurationFlag{
+			Name:  rebaseTimeoutFlag,
+			Usage: "how long to wait for Dependabot to push after asking it to rebase before asking again",
+			Value: 24 * time.Hour,
+		},
+		&cli.IntFlag{
+			Name:  maxRebaseRequestsFlag,
+			Usage: "number of times to ask Dependabot to rebase a PR before asking it to recreate the PR instead",
+			Value: 2,
+		},
+		&cli.StringFlag{
+			Name:  updateBehindFlag,
+			Usage: fmt.Sprintf("how to bring PRs that are behind their base branch up to date so that they can be merged. Valid values: %s", strings.Join(updateBehindModes(), ", ")),
+			Value: updateBehindNone,
+		},
+		&cli.StringFlag{
+			Name:  retryCommentFlag,
+			Usage: "comment that re-triggers the CI patch, used to authorize PRs whose branch is already up to date (set to empty to disable)",
+			Value: "evergreen retry",
+		},
+		&cli.StringSliceFlag{
+			Name:  trustedMergeAuthorsFlag,
+			Usage: "users besides the authenticated user whose verified merge commits are allowed on PRs that are authorized",
+		},
+		&cli.BoolFlag{
+			Name:  authorizeInFreezeFlag,
+			Usage: "authorize PRs even during a merge freeze so that their CI results are ready when the freeze ends",
+			Value: true,
+		},
+		&cli.IntFlag{
+			Name:  maxMergesPerRunFlag,
+			Usage: "maximum number of PRs to merge in a single run (0 means unlimited)",
+		},
+		&cli.IntFlag{
+			Name:  maxMergesPerRepoPerRunFlag,
+			Usage: "maximum number of PRs to merge into a single repo in a single run (0 means unlimited)",
+		},
+		&cli.IntFlag{
+			Name:  maxMergesPerRepoPerDayFlag,
+			Usage: "maximum number of PRs to merge into a single repo in 24 hours, including previous runs (0 means unlimited)",
+		},
+		&cli.BoolFlag{
+			Name:  waitForMainlineFlag,
+			Usage: "before each merge, wait for the build of the PR's base branch to succeed, and stop merging into a repo whose base branch build is failing",
+		},
+		&cli.DurationFlag{
+			Name:  mainlineTimeoutFlag,
+			Usage: fmt.Sprintf("how long to wait for the base branch build when --%s is set", waitForMainlineFlag),
+			Value: 30 * time.Minute,
+		},
+		&cli.BoolFlag{
+			Name:  verifyMergesFlag,
+			Usage: "check the mainline builds of commits merged in previous runs",
+		},
+		&cli.DurationFlag{
+			Name:  verifyTimeoutFlag,
+			Usage: "how long after a merge to wait for statuses on the merged commit before giving up on verifying it",
+			Value: 24 * time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:  revertFailedMergesFlag,
+			Usage: fmt.Sprintf("open a revert PR when a merged commit breaks a passing mainline build, and close PRs that propose the reverted update again while it's held (requires --%s)", verifyMergesFlag),
+		},
+		&cli.DurationFlag{
+			Name:  revertHoldFlag,
+			Usage: "how long to hold updates of a dependency after its merge broke the mainline build",
+			Value: 7 * 24 * time.Hour,
 		},
+		&cli.BoolFlag{
+			Name:  chatOpsFlag,
+			Usage: fmt.Sprintf("handle commands in PR comments from users with write access: '%s <%s>' (%s and %s can name the head commit to apply to, and otherwise only apply if the head commit is older than the comment)", commandPrefix, strings.Join([]string{commandHold, commandRelease, commandMerge, commandAuthorize, commandExplain}, "|"), commandMerge, commandAuthorize),
+		},
+	)
+}
+
+func defaultHTTPCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
 	}
+	return filepath.Join(dir, "treebot", "http")
+}
+
+func newGitHubClient(ctx context.Context, c *cli.Context) (*github.Client, error) {
+	token := os.Getenv("GITHUB_OAUTH_TOKEN")
+	if token == "" {
+		return nil, errors.New("GITHUB_OAUTH_TOKEN environment variable is required")
+	}
+
+	return github.NewClient(ctx, token, github.ClientOptions{
+		CacheDir: c.String(httpCacheDirFlag),
+	})
 }
 
 func AutoAuthorize() *cli.Command {
@@ -67,46 +281,32 @@ func AutoAuthorize() *cli.Command {
 }
 
 func autoAuthorizeDependabotPRsFromNotifications(c *cli.Context) error {
-	token := os.Getenv("GITHUB_OAUTH_TOKEN")
-	if token == "" {
-		return errors.New("GITHUB_OAUTH_TOKEN environment variable is required")
-	}
-
 	zap.S().Info("checking for Dependabot PRs to auto-authorize")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	ghc := github.NewClient(ctx, token)
+	sess, err := newSession(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "setting up session")
+	}
+	defer sess.close()
 
-	notifications, err := getDependabotPRNotifications(ctx, ghc, c)
+	notifications, err := getBotPRNotifications(ctx, sess)
 	if err != nil {
 		return errors.Wrap(err, "getting Dependabot PR notifications")
 	}
 
-	var unresolved []github.PullRequestNotification
-	for i, n := range notifications {
-		zap.S().Infof("Notification #%d: %s", i+1, github.GetLogFormat(n.Notification))
-		zap.S().Infof("URL: %s", github.GetHumanReadableURL(n))
+	results := processNotifications(ctx, sess, notifications, state.ActionAuthorize, checkAndAuthorizeDependabotPR)
 
-		res, err := checkAndAuthorizeDependabotPR(ctx, ghc, c, n)
-		fmt.Println()
-		if err != nil {
-			zap.S().Error(errors.Wrapf(err, "checking and authorizing Dependabot PR patch from notification"))
-			continue
-		}
-
-		if res == skipped {
-			unresolved = append(unresolved, notifications[i])
-		}
-	}
-
-	logUnresolvedNotifications(unresolved)
+	logResults(results)
+	logRateLimits(sess.ghc)
 
 	return nil
 }
 
-func getDependabotPRNotifications(ctx context.Context, ghc *github.Client, c *cli.Context) ([]github.PullRequestNotification, error) {
+func getBotPRNotifications(ctx context.Context, sess *session) ([]github.PullRequestNotification, error) {
+	c := sess.c
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
@@ -116,14 +316,15 @@ func getDependabotPRNotifications(ctx context.Context, ghc *github.Client, c *cl
 		IncludeTitles:  c.StringSlice(includeTitlesFlag),
 		IncludeReasons: c.StringSlice(includeReasonsFlag),
 		IncludeTypes:   []github.NotificationType{github.NotificationTypePullRequest},
+		UseGraphQL:     c.Bool(graphQLFlag),
 	}
 	if c.Bool(checkDependabotUserFlag) {
 		// This check is quite expensive, so put it behind a flag.
-		opts.IncludeUsers = []github.NotificationFromUserOptions{
-			{Name: github.DependabotUsername, Type: github.UserTypeBot},
+		for _, bot := range sess.bots {
+			opts.IncludeUsers = append(opts.IncludeUsers, github.NotificationFromUserOptions{Name: bot.Username, Type: bot.UserType})
 		}
 	}
-	return ghc.GetPRNotifications(ctx, opts)
+	return sess.ghc.GetPRNotifications(ctx, opts)
 }
 
 type operationResult string
@@ -132,77 +333,227 @@ const (
 	done        operationResult = "done"
 	alreadyDone operationResult = "already-done"
 	skipped     operationResult = "skipped"
+	held        operationResult = "held"
+	frozen      operationResult = "frozen"
+	deferred    operationResult = "deferred"
 	errored     operationResult = "errored"
+	// waiting means that nothing blocks the PR, but the action isn't needed
+	// yet, e.g. because the PR's CI hasn't finished.
+	waiting operationResult = "waiting"
+	// notBot means that the PR wasn't opened by any of the enabled bots, so
+	// treebot leaves it alone entirely.
+	notBot operationResult = "not-bot"
 )
 
-func checkAndAuthorizeDependabotPR(ctx context.Context, ghc *github.Client, c *cli.Context, n github.PullRequestNotification) (operationResult, error) {
+func checkAndAuthorizeDependabotPR(ctx context.Context, sess *session, log *trace, n github.PullRequestNotification) (operationResult, error) {
 	pr := n.PullRequest
 
+	bot, ok := sess.checkBot(log, n)
+	if !ok {
+		return notBot, nil
+	}
 	if state := pr.GetState(); state != github.PRStateOpen {
-		zap.S().Debugf("skipping because PR state is '%s'", state)
+		log.failf("open", "PR state is '%s'", state)
 		if state == github.PRStateClosed {
 			return alreadyDone, nil
 		}
 		return skipped, nil
 	}
-	if numCommits := pr.GetCommits(); numCommits != 1 {
-		zap.S().Debugf("skipping PR which has %d commits - auto-authorization requires that there should be exactly 1 Dependabot commit", numCommits)
+	log.pass("open", "PR is open")
+	updates := sess.dependencyUpdates(ctx, log, n, bot)
+	if !sess.checkDependencyRules(log, n, updates) {
 		return skipped, nil
 	}
-
+	if sess.checkHeld(log, n, updates) {
+		if err := sess.closeHeldUpdate(ctx, log, n, bot, updates); err != nil {
+			return errored, err
+		}
+		return held, nil
+	}
+	if !sess.checkFreeze(log, n, state.ActionAuthorize) {
+		return frozen, nil
+	}
+	if res, ok := sess.checkHistory(log, n, state.ActionAuthorize); !ok {
+		return res, nil
+	}
 	getCommitStatusCtx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
-	statuses, err := ghc.GetCommitStatusesFromNotification(getCommitStatusCtx, n)
+	statuses, err := sess.ghc.GetCommitStatusesFromNotification(getCommitStatusCtx, n)
 	if err != nil {
 		return errored, errors.Wrap(err, "getting Dependabot PR status")
 	}
 
-	// Only consider PRs for which there's only 1 status ("failure",
-	// "patch must be manually authorized") and there's a single
-	// Dependabot-authored commit.
-
-	if len(statuses) != 1 {
-		zap.S().Debugf("skipping notification because it has multiple commit statuses available, but there should be exactly 1 failed commit status for a Dependabot PR in need of manual authorization")
-		return skipped, nil
-	}
-	latest := statuses[0]
-	if state := latest.GetState(); state != github.CommitStatusFailure {
-		zap.S().Debugf("skipping notification because latest commit status should be a failure for a Dependabot PR in need of manual authorization, but the actual commit status is '%s'", state)
-		return skipped, nil
+	rule := ruleDependabotManualAuthorization
+	if user := sess.forcedBy(n, state.ActionAuthorize); user != "" {
+		log.pass("forced", "authorization was forced by user '%s' with '%s %s'", user, commandPrefix, commandAuthorize)
+		rule = ruleForcedByCommand
+	} else {
+		log.notApplicable("forced", "authorization was not forced with '%s %s'", commandPrefix, commandAuthorize)
+		if !needsManualAuthorization(log, statuses) {
+			return waiting, nil
+		}
+		ok, err := sess.checkAuthorizableCommits(getCommitStatusCtx, log, n, bot)
+		if err != nil {
+			return errored, errors.Wrap(err, "checking Dependabot PR commits")
+		}
+		if !ok {
+			return skipped, nil
+		}
+		name, ok := sess.checkPolicies(log, n, state.ActionAuthorize, bot, updates, statuses)
+		if !ok {
+			return skipped, nil
+		}
+		if name != "" {
+			rule = policyRule(name)
+		}
 	}
-	if latest.GetDescription() != "patch must be manually authorized" {
-		zap.S().Debugf("skipping notification because it contains a commit status message other than the manual patch authorization message")
-		return skipped, nil
+
+	if log.explaining {
+		return done, nil
 	}
 
-	if c.Bool(interactiveFlag) {
+	if sess.c.Bool(interactiveFlag) {
 		fmt.Println()
 		yes, err := yesOrNo("Authorize this PR?")
 		if err != nil {
 			return errored, errors.Wrap(err, "asking user to authorize Dependabot PR")
 		}
 		if !yes {
+			log.skipf("user declined to authorize the PR")
 			return skipped, nil
 		}
 		fmt.Println()
 	}
 
-	zap.S().Infow("authorizing Dependabot PR",
+	log.Infow("authorizing Dependabot PR",
 		"title", pr.GetTitle(),
 		"url", pr.GetURL(),
+		"updates", formatUpdates(updates),
 	)
 
 	updatePRCtx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
-	if err := ghc.UpdatePRFromNotification(updatePRCtx, n); err != nil {
-		return errored, errors.Wrap(err, "updating Dependabot PR")
+	// Updating the branch creates a new patch that's authorized. If the
+	// branch is already up to date, Evergreen can be asked to retry the patch
+	// instead.
+	outcome, err := sess.ghc.UpdatePRFromNotification(updatePRCtx, n)
+	if comment := sess.c.String(retryCommentFlag); outcome == github.UpdateBranchUpToDate && comment != "" {
+		log.Infof("PR branch is already up to date, so authorizing it with a '%s' comment instead", comment)
+		if _, err = sess.ghc.CreatePRComment(updatePRCtx, n, comment); err != nil {
+			err = errors.Wrap(err, "posting retry comment")
+		}
+	}
+	sess.recordAction(log, n, actionRecord{
+		action:   state.ActionAuthorize,
+		rule:     rule,
+		statuses: statuses,
+		updates:  updates,
+	}, err)
+	switch {
+	case err == nil:
+	case outcome == github.UpdateBranchConflict, outcome == github.UpdateBranchPermission:
+		log.skipf("the PR's branch could not be updated to authorize it (%s)", outcome)
+		return skipped, nil
+	default:
+		return errored, errors.Wrap(err, "authorizing Dependabot PR")
+	}
+	if outcome == github.UpdateBranchAccepted {
+		sess.recordBranchUpdate(log, n, updateBehindBranch)
 	}
 
 	return done, nil
 }
 
+// needsManualAuthorization checks that the PR's Evergreen patch is waiting for
+// manual authorization. Only consider PRs for which there's only 1 status
+// ("failure", "patch must be manually authorized").
+func needsManualAuthorization(log *trace, statuses []gogithub.RepoStatus) bool {
+	if len(statuses) != 1 {
+		log.failf("manual authorization", "PR has %d commit statuses, but there should be exactly 1 failed commit status for a Dependabot PR in need of manual authorization", len(statuses))
+		return false
+	}
+	latest := statuses[0]
+	if state := latest.GetState(); state != github.CommitStatusFailure {
+		log.failf("manual authorization", "latest commit status should be a failure for a Dependabot PR in need of manual authorization, but the actual commit status is '%s'", state)
+		return false
+	}
+	if latest.GetDescription() != "patch must be manually authorized" {
+		log.failf("manual authorization", "PR has a commit status message other than the manual patch authorization message")
+		return false
+	}
+
+	log.pass("manual authorization", "the patch is waiting for manual authorization")
+	return true
+}
+
+// maxAuthorizableCommits is the most commits that can be listed for a PR.
+const maxAuthorizableCommits = 250
+
+// checkAuthorizableCommits checks that every commit on the PR can be trusted:
+// every non-merge commit must be a verified commit from the bot, and every
+// merge commit must be a verified commit authored by a trusted identity, such
+// as treebot itself when it updates the PR's branch.
+func (sess *session) checkAuthorizableCommits(ctx context.Context, log *trace, n github.PullRequestNotification, bot github.BotProfile) (bool, error) {
+	if numCommits := n.PullRequest.GetCommits(); numCommits > maxAuthorizableCommits {
+		log.failf("commits", "PR has %d commits, but at most %d commits can be checked", numCommits, maxAuthorizableCommits)
+		return false, nil
+	}
+
+	commits, err := sess.ghc.GetCommitsFromNotification(ctx, n)
+	if err != nil {
+		return false, errors.Wrap(err, "getting PR commits")
+	}
+	headSHA := n.PullRequest.GetHead().GetSHA()
+	if len(commits) != 0 && commits[len(commits)-1].GetSHA() != headSHA {
+		log.failf("commits", "the PR's commits end at '%s' rather than its head commit '%s', so the PR changed while it was being checked", shortSHA(commits[len(commits)-1].GetSHA()), shortSHA(headSHA))
+		return false, nil
+	}
+
+	trusted := map[string]bool{sess.actor: true}
+	for _, user := range sess.c.StringSlice(trustedMergeAuthorsFlag) {
+		trusted[user] = true
+	}
+
+	var botCommits int
+	for _, c := range commits {
+		sha := shortSHA(c.GetSHA())
+		author := c.GetAuthor().GetLogin()
+		if len(c.Parents) > 1 {
+			if !trusted[author] {
+				log.failf("commits", "merge commit '%s' is authored by '%s', who is not a trusted merge commit author", sha, author)
+				return false, nil
+			}
+			// Merge commits made through GitHub, such as by updating the PR's
+			// branch, are signed by GitHub. The author alone can't be trusted
+			// because anyone can push a commit with a trusted user's email.
+			if v := c.GetCommit().GetVerification(); !v.GetVerified() {
+				log.failf("commits", "merge commit '%s' from '%s' is not verified (reason: '%s')", sha, author, v.GetReason())
+				return false, nil
+			}
+			continue
+		}
+
+		if author != bot.Username {
+			log.failf("commits", "commit '%s' is authored by '%s', but all non-merge commits should be from '%s'", sha, author, bot.Username)
+			return false, nil
+		}
+		if v := c.GetCommit().GetVerification(); !v.GetVerified() {
+			log.failf("commits", "commit '%s' from '%s' is not verified (reason: '%s')", sha, bot.Username, v.GetReason())
+			return false, nil
+		}
+		botCommits++
+	}
+	if botCommits == 0 {
+		log.failf("commits", "PR has no commits from '%s'", bot.Username)
+		return false, nil
+	}
+
+	log.pass("commits", "every commit is a verified commit from '%s' or a verified merge commit from a trusted user", bot.Username)
+	return true, nil
+}
+
 func yesOrNo(message string) (bool, error) {
 	for {
 		fmt.Printf("%s [y/n] ", message)
@@ -224,15 +575,36 @@ func yesOrNo(message string) (bool, error) {
 	}
 }
 
-func logUnresolvedNotifications(notifications []github.PullRequestNotification) {
+// logResults logs the notifications that still need attention or that were
+// deliberately left alone.
+func logResults(results map[operationResult][]github.PullRequestNotification) {
+	logNotifications("Held notifications:", results[held])
+	logNotifications("Frozen notifications:", results[frozen])
+	logNotifications("Deferred notifications:", results[deferred])
+	logNotifications("Waiting notifications:", results[waiting])
+	logNotifications("Unresolved notifications:", results[skipped])
+}
+
+func logNotifications(title string, notifications []github.PullRequestNotification) {
 	if len(notifications) == 0 {
 		return
 	}
 
-	zap.S().Info("Unresolved notifications:")
+	zap.S().Info(title)
 	for _, n := range notifications {
 		zap.S().Infof("Notification: %s", github.GetLogFormat(n.Notification))
 		zap.S().Infof("URL: %s", github.GetHumanReadableURL(n))
 		zap.S().Info()
 	}
 }
+
+func logRateLimits(ghc *github.Client) {
+	for _, l := range ghc.RateLimits() {
+		zap.S().Infow("GitHub API rate limit",
+			"resource", l.Resource,
+			"remaining", l.Remaining,
+			"limit", l.Limit,
+			"reset", l.Reset,
+		)
+	}
+}
@@ -3,11 +3,12 @@ package operations
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
+	gogithub "github.com/google/go-github/v40/github"
 	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
@@ -26,46 +27,55 @@ func AutoMerge() *cli.Command {
 }
 
 func autoMergeDependabotPRsFromNotifications(c *cli.Context) error {
-	token := os.Getenv("GITHUB_OAUTH_TOKEN")
-	if token == "" {
-		return errors.New("GITHUB_OAUTH_TOKEN environment variable is required")
-	}
-
 	zap.S().Info("checking for Dependabot PRs to auto-merge")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	ghc := github.NewClient(ctx, token)
-
-	notifications, err := getDependabotPRNotifications(ctx, ghc, c)
+	sess, err := newSession(ctx, c)
 	if err != nil {
-		return errors.Wrap(err, "getting Dependabot PR notifications")
+		return errors.Wrap(err, "setting up session")
 	}
+	defer sess.close()
 
-	var unresolved []github.PullRequestNotification
-	for i, n := range notifications {
-		zap.S().Infof("Notification #%d: %s", i+1, github.GetLogFormat(n.Notification))
-		zap.S().Infof("URL: %s", github.GetHumanReadableURL(n))
-
-		res, err := checkAndMergeDependabotPR(ctx, ghc, c, n)
-		fmt.Println()
-		if err != nil {
-			zap.S().Error(errors.Wrapf(err, "checking and merging Dependabot PR from notification"))
-			continue
-		}
+	sess.verifyMergedCommits(ctx)
+	sess.resolveCombinedPRs(ctx)
 
-		if res == skipped {
-			unresolved = append(unresolved, notifications[i])
-		}
+	notifications, err := getBotPRNotifications(ctx, sess)
+	if err != nil {
+		return errors.Wrap(err, "getting Dependabot PR notifications")
 	}
 
-	logUnresolvedNotifications(unresolved)
+	results := processNotifications(ctx, sess, notifications, state.ActionMerge, checkAndMergeDependabotPR)
+
+	logResults(results)
+	logRateLimits(sess.ghc)
 
 	return nil
 }
 
-func checkAndMergeDependabotPR(ctx context.Context, ghc *github.Client, c *cli.Context, n github.PullRequestNotification) (operationResult, error) {
+func checkAndMergeDependabotPR(ctx context.Context, sess *session, log *trace, n github.PullRequestNotification) (operationResult, error) {
+	bot, ok := sess.checkBot(log, n)
+	if !ok {
+		return notBot, nil
+	}
+	updates := sess.dependencyUpdates(ctx, log, n, bot)
+	if !sess.checkDependencyRules(log, n, updates) {
+		return skipped, nil
+	}
+	if sess.checkHeld(log, n, updates) {
+		if err := sess.closeHeldUpdate(ctx, log, n, bot, updates); err != nil {
+			return errored, err
+		}
+		return held, nil
+	}
+	if !sess.checkFreeze(log, n, state.ActionMerge) {
+		return frozen, nil
+	}
+	if res, ok := sess.checkHistory(log, n, state.ActionMerge); !ok {
+		return res, nil
+	}
+
 	var mergeable bool
 	pr := n.PullRequest
 	// A PR might not be immediately mergeable if a previous PR was just merged
@@ -75,14 +85,17 @@ func checkAndMergeDependabotPR(ctx context.Context, ghc *github.Client, c *cli.C
 		getPRCtx, cancel := context.WithTimeout(ctx, time.Minute)
 		defer cancel()
 
-		latestPR, err := ghc.GetPRFromNotification(getPRCtx, n.Notification)
+		latestPR, err := sess.ghc.RefreshPRFromNotification(getPRCtx, n.Notification)
 		if err != nil {
 			return errored, errors.Wrap(err, "getting PR from notification")
 		}
 		pr = *latestPR
+		// The snapshot is as old as the notifications, so its statuses are
+		// fetched again along with the PR.
+		n.Snapshot = nil
 
 		if state := pr.GetState(); state != github.PRStateOpen {
-			zap.S().Debugf("skipping because PR state is '%s'", state)
+			log.failf("open", "PR state is '%s'", state)
 			if state == github.PRStateClosed {
 				return alreadyDone, nil
 			}
@@ -93,16 +106,25 @@ func checkAndMergeDependabotPR(ctx context.Context, ghc *github.Client, c *cli.C
 		case github.MergeableStateClean:
 		case github.MergeableStateUnstable:
 		case github.MergeableStateUnknown:
-			zap.S().Debugf("PR check attempt #%d: uncertain if PR is mergeable", i+1)
+			log.Debugf("PR check attempt #%d: uncertain if PR is mergeable", i+1)
 			time.Sleep(time.Second)
 			continue
+		case github.MergeableStateBehind:
+			n.PullRequest = pr
+			return sess.updateBehindPR(ctx, log, n, bot)
+		case github.MergeableStateDirty:
+			n.PullRequest = pr
+			if err := sess.requestRebase(ctx, log, n, bot); err != nil {
+				return errored, errors.Wrap(err, "asking bot to resolve merge conflicts")
+			}
+			return skipped, nil
 		default:
-			zap.S().Debugf("skipping PR because it is not cleanly mergeable - mergeable status is '%s'", pr.GetMergeableState())
+			log.failf("mergeable", "PR is not cleanly mergeable - mergeable status is '%s'", pr.GetMergeableState())
 			return skipped, nil
 		}
 
 		if !pr.GetMergeable() {
-			zap.S().Debugf("PR check attempt #%d: PR is not mergeable", i+1)
+			log.Debugf("PR check attempt #%d: PR is not mergeable", i+1)
 			time.Sleep(time.Second)
 			continue
 		}
@@ -110,78 +132,165 @@ func checkAndMergeDependabotPR(ctx context.Context, ghc *github.Client, c *cli.C
 		mergeable = true
 	}
 	if !mergeable {
-		zap.S().Debugf("skipping because PR is not mergeable")
-		return skipped, nil
+		log.failf("mergeable", "PR is not mergeable")
+		return waiting, nil
 	}
+	log.pass("open", "PR is open")
+	log.pass("mergeable", "mergeable state is '%s'", pr.GetMergeableState())
 
-	commits, err := ghc.GetCommitsFromNotification(ctx, n)
-	if err != nil {
-		return errored, errors.Wrap(err, "getting commits from notification")
-	}
-	if len(commits) == 0 {
-		zap.S().Debugf("skipping because PR has no commits")
+	n.PullRequest = pr
+	sess.clearRebase(log, n)
+
+	if pr.GetCommits() == 0 {
+		log.failf("commits", "PR has no commits")
 		return skipped, nil
 	}
+	log.pass("commits", "PR has %d commit(s)", pr.GetCommits())
 
-	latest := commits[len(commits)-1]
-	status, err := ghc.GetCombinedStatusFromNotificationAndCommit(ctx, n.Notification, latest)
+	status, err := sess.ghc.GetCombinedStatusFromNotification(ctx, n)
 	if err != nil {
 		return errored, errors.Wrap(err, "getting statuses from latest commit")
 	}
+	if sha := status.GetSHA(); sha != pr.GetHead().GetSHA() {
+		log.failf("statuses", "the statuses are for commit '%s', but the PR's head commit moved to '%s'", shortSHA(sha), shortSHA(pr.GetHead().GetSHA()))
+		return waiting, nil
+	}
 	if len(status.Statuses) == 0 {
-		zap.S().Debugf("skipping notification because the latest commit has no statuses available")
-		return skipped, nil
+		log.failf("statuses", "the latest commit has no statuses available")
+		return waiting, nil
 	}
 
 	if state := status.GetState(); state != github.CombinedStatusSuccess {
-		zap.S().Debugf("skipping notification because the latest commit's status is '%s'", state)
+		log.failf("statuses", "the latest commit's status is '%s'", state)
+		if state == github.CombinedStatusPending {
+			return waiting, nil
+		}
 		return skipped, nil
 	}
 
 	var patchFinished bool
 	for i, s := range status.Statuses {
-		zap.S().Infow(fmt.Sprintf("status #%d:", i+1),
+		log.Infow(fmt.Sprintf("status #%d:", i+1),
 			"context", s.GetContext(),
 			"state", s.GetState(),
 			"description", s.GetDescription(),
 			"target_url", s.GetTargetURL(),
 		)
 		if state := s.GetState(); state == github.CommitStatusFailure {
-			zap.S().Debugf("skipping notification because its latest commit cannot have a failure for a Dependabot PR, but the actual commit status is '%s'", state)
+			log.failf("statuses", "the latest commit cannot have a failure for a Dependabot PR, but the actual status of '%s' is '%s'", s.GetContext(), state)
 			return skipped, nil
 		}
 		if strings.Contains(s.GetDescription(), "patch finished") {
 			patchFinished = true
 		}
 	}
-	if !patchFinished {
-		zap.S().Debugf("skipping notification because the commit status messages indicate that the patch has not finished")
-		return skipped, nil
+	log.pass("statuses", "all %d status(es) of the latest commit succeeded", len(status.Statuses))
+
+	rule := ruleDependabotChecksPassed
+	if user := sess.forcedBy(n, state.ActionMerge); user != "" {
+		log.pass("forced", "merge was forced by user '%s' with '%s %s'", user, commandPrefix, commandMerge)
+		log.notApplicable("patch finished", "merge was forced")
+		rule = ruleForcedByCommand
+	} else {
+		log.notApplicable("forced", "merge was not forced with '%s %s'", commandPrefix, commandMerge)
+		if !patchFinished {
+			log.failf("patch finished", "the commit status messages indicate that the patch has not finished")
+			return waiting, nil
+		}
+		log.pass("patch finished", "the commit status messages indicate that the patch finished")
+	}
+
+	var statuses []gogithub.RepoStatus
+	for _, s := range status.Statuses {
+		statuses = append(statuses, *s)
+	}
+	if rule != ruleForcedByCommand {
+		name, ok := sess.checkPolicies(log, n, state.ActionMerge, bot, updates, statuses)
+		if !ok {
+			return skipped, nil
+		}
+		if name != "" {
+			rule = policyRule(name)
+		}
+	} else {
+		log.notApplicable("policies", "merge was forced")
 	}
 
-	if c.Bool(interactiveFlag) {
+	ok, err = sess.waitForMainline(ctx, log, n)
+	if err != nil {
+		return errored, errors.Wrap(err, "waiting for mainline build")
+	}
+	if !ok {
+		return deferred, nil
+	}
+
+	repo := n.Notification.Repository.GetFullName()
+	if reason, ok := sess.mergeBudget.reserve(repo); !ok {
+		log.failf("merge budget", "%s", reason)
+		return deferred, nil
+	}
+	log.pass("merge budget", "the merge limits have not been reached")
+
+	if log.explaining {
+		sess.mergeBudget.release(repo)
+		return done, nil
+	}
+
+	if sess.c.Bool(interactiveFlag) {
 		fmt.Println()
 		yes, err := yesOrNo("Merge this PR?")
 		if err != nil {
+			sess.mergeBudget.release(repo)
 			return errored, errors.Wrap(err, "asking user to merge Dependabot PR")
 		}
 		if !yes {
+			sess.mergeBudget.release(repo)
+			log.skipf("user declined to merge the PR")
 			return skipped, nil
 		}
 		fmt.Println()
 	}
 
-	zap.S().Infow("merging Dependabot PR",
+	log.Infow("merging Dependabot PR",
 		"title", pr.GetTitle(),
 		"url", pr.GetURL(),
+		"updates", formatUpdates(updates),
 	)
 
 	mergePRCtx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
-	if err := ghc.MergePRFromNotification(mergePRCtx, n); err != nil {
+	sha, err := sess.ghc.MergePRFromNotification(mergePRCtx, n)
+	sess.recordAction(log, n, actionRecord{
+		action:   state.ActionMerge,
+		rule:     rule,
+		statuses: statuses,
+		updates:  updates,
+	}, err)
+	if err != nil {
+		sess.mergeBudget.release(repo)
 		return errored, errors.Wrap(err, "merging Dependabot PR")
 	}
 
+	if err := sess.store.RecordMergedCommit(repo, state.MergedCommit{
+		SHA:          sha,
+		Branch:       pr.GetBase().GetRef(),
+		PRNumber:     pr.GetNumber(),
+		Title:        pr.GetTitle(),
+		MergedAt:     time.Now(),
+		Dependencies: dependencyNames(updates),
+	}); err != nil {
+		log.Warn(errors.Wrap(err, "recording merged commit in state store"))
+	}
+
+	if sess.c.Bool(unsubscribeFlag) {
+		unsubscribeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		if err := sess.ghc.UnsubscribeFromNotification(unsubscribeCtx, n.Notification); err != nil {
+			log.Warn(errors.Wrap(err, "unsubscribing from merged PR's notifications"))
+		}
+	}
+
 	return done, nil
 }
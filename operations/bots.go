@@ -0,0 +1,164 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kimchelly/treebot-go/config"
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/pkg/errors"
+)
+
+// botProfiles returns the profiles of the bots with the given names, looking
+// them up among the built-in bots and the custom bots in the config.
+func botProfiles(names []string, conf *config.Config) ([]github.BotProfile, error) {
+	available := map[string]github.BotProfile{}
+	for _, p := range github.BuiltinBotProfiles() {
+		available[p.Name] = p
+	}
+	for _, b := range conf.Bots {
+		p := github.BotProfile{
+			Name:            b.Name,
+			Username:        b.Username,
+			UserType:        github.UserType(b.UserType),
+			BranchPrefix:    b.BranchPrefix,
+			RebaseCommand:   b.RebaseCommand,
+			RecreateCommand: b.RecreateCommand,
+		}
+		if b.TitlePattern != "" {
+			parse, err := github.NewRegexpTitleParser(b.TitlePattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "bot '%s'", b.Name)
+			}
+			p.ParseTitle = parse
+		}
+		if b.MetadataPattern != "" {
+			parse, err := github.NewRegexpMetadataParser(b.MetadataPattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "bot '%s'", b.Name)
+			}
+			p.ParseMetadata = parse
+		}
+		available[b.Name] = p
+	}
+
+	var profiles []github.BotProfile
+	for _, name := range names {
+		p, ok := available[name]
+		if !ok {
+			return nil, errors.Errorf("unknown bot '%s'", name)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// botFor returns the profile of the enabled bot that opened the PR, if any.
+func (sess *session) botFor(n github.PullRequestNotification) (github.BotProfile, bool) {
+	for _, p := range sess.bots {
+		if p.MatchesPR(n.PullRequest) {
+			return p, true
+		}
+	}
+	return github.BotProfile{}, false
+}
+
+// checkBot checks that the PR was opened by one of the enabled bots and
+// returns the bot's profile.
+func (sess *session) checkBot(log *trace, n github.PullRequestNotification) (github.BotProfile, bool) {
+	bot, ok := sess.botFor(n)
+	if !ok {
+		var names []string
+		for _, p := range sess.bots {
+			names = append(names, p.Name)
+		}
+		log.failf("bot", "PR was opened by '%s' from branch '%s', which doesn't match any of the enabled bots (%s)", n.PullRequest.GetUser().GetLogin(), n.PullRequest.GetHead().GetRef(), strings.Join(names, ", "))
+		return github.BotProfile{}, false
+	}
+	log.pass("bot", "PR was opened by '%s' from branch '%s', which matches the '%s' bot", n.PullRequest.GetUser().GetLogin(), n.PullRequest.GetHead().GetRef(), bot.Name)
+	return bot, true
+}
+
+// dependencyUpdates returns the dependency updates in the PR. The title is
+// enough for most PRs, but grouped updates only list their members in the PR
+// description and in the bot's commit metadata, so those are parsed too when
+// the title doesn't say what changed.
+func (sess *session) dependencyUpdates(ctx context.Context, log *trace, n github.PullRequestNotification, bot github.BotProfile) []github.DependencyUpdate {
+	updates := sess.parseDependencyUpdates(ctx, log, n, bot)
+	if len(updates) != 0 {
+		log.pass("dependency updates", "%s", strings.Join(formatUpdates(updates), "; "))
+	} else {
+		log.notApplicable("dependency updates", "the dependency updates could not be determined from the PR title, description or commits")
+	}
+	return updates
+}
+
+func (sess *session) parseDependencyUpdates(ctx context.Context, log *trace, n github.PullRequestNotification, bot github.BotProfile) []github.DependencyUpdate {
+	title := n.PullRequest.GetTitle()
+	updates := bot.UpdatesFromTitle(title)
+	if bot.UpdatesComplete(title, updates) {
+		return updates
+	}
+	updates = github.MergeDependencyUpdates(updates, bot.UpdatesFromBody(n.PullRequest.GetBody()))
+	if bot.UpdatesComplete(title, updates) || bot.ParseMetadata == nil {
+		return updates
+	}
+
+	commitsCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	commits, err := sess.ghc.GetCommitsFromNotification(commitsCtx, n)
+	if err != nil {
+		log.Warn(errors.Wrap(err, "getting commits to parse dependency metadata"))
+		return updates
+	}
+	sources := [][]github.DependencyUpdate{updates}
+	for _, c := range commits {
+		sources = append(sources, bot.UpdatesFromMetadata(c.GetCommit().GetMessage()))
+	}
+	return github.MergeDependencyUpdates(sources...)
+}
+
+// dependencyNames returns the names of the updated dependencies, once each
+// even if they're updated in several directories.
+func dependencyNames(updates []github.DependencyUpdate) []string {
+	var names []string
+	for _, u := range updates {
+		if !containsString(names, u.Name) {
+			names = append(names, u.Name)
+		}
+	}
+	return names
+}
+
+// formatUpdate describes the version change of a dependency update.
+func formatUpdate(u github.DependencyUpdate) string {
+	s := u.Name
+	if u.From != "" || u.To != "" {
+		s += fmt.Sprintf(" %s -> %s", orUnknown(u.From), orUnknown(u.To))
+	}
+	if t := u.UpdateType(); t != "" {
+		s += fmt.Sprintf(" (%s)", t)
+	}
+	if u.Directory != "" {
+		s += fmt.Sprintf(" in %s", u.Directory)
+	}
+	return s
+}
+
+func formatUpdates(updates []github.DependencyUpdate) []string {
+	formatted := make([]string, 0, len(updates))
+	for _, u := range updates {
+		formatted = append(formatted, formatUpdate(u))
+	}
+	return formatted
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "?"
+	}
+	return s
+}
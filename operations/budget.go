@@ -0,0 +1,65 @@
+package operations
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kimchelly/treebot-go/state"
+)
+
+// mergeBudget limits how many PRs are merged so that mainline breakages are
+// easier to bisect. A limit of zero means unlimited.
+type mergeBudget struct {
+	perRun        int
+	perRepoPerRun int
+	perRepoPerDay int
+	store         *state.Store
+
+	mu         sync.Mutex
+	runMerges  int
+	repoMerges map[string]int
+}
+
+func newMergeBudget(perRun, perRepoPerRun, perRepoPerDay int, store *state.Store) *mergeBudget {
+	return &mergeBudget{
+		perRun:        perRun,
+		perRepoPerRun: perRepoPerRun,
+		perRepoPerDay: perRepoPerDay,
+		store:         store,
+		repoMerges:    map[string]int{},
+	}
+}
+
+// reserve uses up one merge from the budget for the repo. If the budget is
+// already used up, it returns the reason why the merge can't happen.
+func (b *mergeBudget) reserve(repo string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.perRun > 0 && b.runMerges >= b.perRun {
+		return fmt.Sprintf("the limit of %d merges per run has been reached", b.perRun), false
+	}
+	if b.perRepoPerRun > 0 && b.repoMerges[repo] >= b.perRepoPerRun {
+		return fmt.Sprintf("the limit of %d merges into this repo per run has been reached", b.perRepoPerRun), false
+	}
+	if b.perRepoPerDay > 0 {
+		if merged := b.store.MergesSince(repo, time.Now().Add(-24*time.Hour)); merged >= b.perRepoPerDay {
+			return fmt.Sprintf("the limit of %d merges into this repo per day has been reached (%d merged in the last 24 hours)", b.perRepoPerDay, merged), false
+		}
+	}
+
+	b.runMerges++
+	b.repoMerges[repo]++
+
+	return "", true
+}
+
+// release returns a reserved merge to the budget if the merge didn't happen.
+func (b *mergeBudget) release(repo string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.runMerges--
+	b.repoMerges[repo]--
+}
@@ -0,0 +1,236 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+)
+
+// commandPrefix starts a line in a PR comment that contains a command for
+// treebot.
+const commandPrefix = "/treebot"
+
+// Commands that users can give treebot in PR comments.
+const (
+	// commandHold stops treebot from acting on the PR.
+	commandHold = "hold"
+	// commandRelease undoes commandHold.
+	commandRelease = "release"
+	// commandMerge merges the PR once its checks pass, regardless of
+	// treebot's other requirements for merging.
+	commandMerge = "merge"
+	// commandAuthorize authorizes the PR regardless of treebot's usual
+	// requirements for authorization.
+	commandAuthorize = "authorize"
+	// commandExplain replies with treebot's current decision about the PR.
+	commandExplain = "explain"
+)
+
+// replyMarker identifies comments that treebot posts in reply to commands.
+const replyMarker = "<!-- treebot:reply -->"
+
+// minCommandSHALength is the shortest abbreviation of a commit SHA that can
+// be named in a command.
+const minCommandSHALength = 7
+
+// command is a command for treebot in a PR comment, such as "/treebot merge"
+// or "/treebot merge 1a2b3c4".
+type command struct {
+	name string
+	// sha is the commit that the user named, if any.
+	sha string
+}
+
+// parseCommands returns the commands in the comment body.
+func parseCommands(body string) []command {
+	var commands []command
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != commandPrefix {
+			continue
+		}
+		cmd := command{name: strings.ToLower(fields[1])}
+		if len(fields) > 2 {
+			cmd.sha = strings.ToLower(fields[2])
+		}
+		commands = append(commands, cmd)
+	}
+	return commands
+}
+
+// isForced returns whether the command forces an action.
+func (cmd command) isForced() bool {
+	return cmd.name == commandMerge || cmd.name == commandAuthorize
+}
+
+// appliesToHead returns whether a forced action applies to the PR's head
+// commit. If the user named a commit, it must be the head commit. Otherwise,
+// the head commit must have been committed before the comment was posted, so
+// that commits pushed after the comment aren't forced without anyone having
+// looked at them. Since whoever pushes a commit can choose its commit date,
+// naming the commit is the stricter way to force an action.
+func (cmd command) appliesToHead(headSHA string, headCommittedAt, commentedAt time.Time) bool {
+	if cmd.sha != "" {
+		return len(cmd.sha) >= minCommandSHALength && strings.HasPrefix(headSHA, cmd.sha)
+	}
+	return headCommittedAt.Before(commentedAt)
+}
+
+// applyCommand updates the PR record for the command given by the user. Forced
+// actions only apply to the given head commit. It returns false if the command
+// is not recognized.
+func applyCommand(r *state.PRRecord, command, user, headSHA string) bool {
+	switch command {
+	case commandHold:
+		r.Hold = &state.Hold{By: user, At: time.Now()}
+	case commandRelease:
+		r.Hold = nil
+	case commandMerge, commandAuthorize:
+		if r.Forced == nil {
+			r.Forced = map[state.Action]state.ForcedAction{}
+		}
+		r.Forced[state.Action(command)] = state.ForcedAction{
+			By:      user,
+			HeadSHA: headSHA,
+			At:      time.Now(),
+		}
+	case commandExplain:
+		r.ExplainRequestedBy = user
+	default:
+		return false
+	}
+	return true
+}
+
+// handleCommands handles any new commands in the PR's comments. Commands are
+// only accepted from users with write access to the repo. Each comment is only
+// handled once.
+func (sess *session) handleCommands(ctx context.Context, log *trace, n github.PullRequestNotification) error {
+	if !sess.c.Bool(chatOpsFlag) || n.PullRequest.GetState() != github.PRStateOpen {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	comments, err := sess.ghc.ListPRComments(ctx, n)
+	if err != nil {
+		return errors.Wrap(err, "listing PR comments")
+	}
+
+	repo := n.Notification.Repository.GetFullName()
+	prNum := n.PullRequest.GetNumber()
+	record := sess.store.PR(repo, prNum)
+	permissions := map[string]string{}
+
+	headSHA := n.PullRequest.GetHead().GetSHA()
+	var headCommittedAt *time.Time
+	getHeadCommittedAt := func() (time.Time, error) {
+		if headCommittedAt == nil {
+			owner, name := splitRepo(repo)
+			date, err := sess.ghc.GetCommitDate(ctx, owner, name, headSHA)
+			if err != nil {
+				return time.Time{}, errors.Wrap(err, "getting date of head commit")
+			}
+			headCommittedAt = &date
+		}
+		return *headCommittedAt, nil
+	}
+
+	for _, comment := range comments {
+		if record.IsCommentHandled(comment.GetID()) || strings.Contains(comment.GetBody(), replyMarker) {
+			continue
+		}
+		commands := parseCommands(comment.GetBody())
+		if len(commands) == 0 {
+			continue
+		}
+
+		user := comment.GetUser().GetLogin()
+		permission, ok := permissions[user]
+		if !ok {
+			permission, err = sess.ghc.GetUserPermission(ctx, n.Notification, user)
+			if err != nil {
+				return errors.Wrapf(err, "getting permission for user '%s'", user)
+			}
+			permissions[user] = permission
+		}
+
+		appliesToHead := map[command]bool{}
+		for _, cmd := range commands {
+			if !cmd.isForced() {
+				continue
+			}
+			committedAt, err := getHeadCommittedAt()
+			if err != nil {
+				return err
+			}
+			appliesToHead[cmd] = cmd.appliesToHead(headSHA, committedAt, comment.GetCreatedAt())
+		}
+
+		reaction := github.ReactionThumbsUp
+		if err := sess.store.UpdatePR(repo, prNum, func(r *state.PRRecord) {
+			r.HandledComments = append(r.HandledComments, comment.GetID())
+			if !github.HasWriteAccess(permission) {
+				log.Infof("ignoring commands from user '%s' who does not have write access", user)
+				reaction = github.ReactionConfused
+				return
+			}
+			for _, cmd := range commands {
+				if cmd.isForced() && !appliesToHead[cmd] {
+					log.Infof("ignoring command '%s' from user '%s' because it doesn't apply to head commit '%s'", cmd.name, user, shortSHA(headSHA))
+					reaction = github.ReactionConfused
+					continue
+				}
+				if !applyCommand(r, cmd.name, user, headSHA) {
+					log.Infof("ignoring unrecognized command '%s' from user '%s'", cmd.name, user)
+					reaction = github.ReactionConfused
+					continue
+				}
+				log.Infof("handled command '%s' from user '%s'", cmd.name, user)
+			}
+		}); err != nil {
+			return errors.Wrap(err, "recording commands in state store")
+		}
+
+		if err := sess.ghc.ReactToPRComment(ctx, n, comment.GetID(), reaction); err != nil {
+			log.Warn(errors.Wrap(err, "reacting to command comment"))
+		}
+	}
+
+	return nil
+}
+
+// replyToExplain replies to a user's request to explain treebot's decision
+// about the PR.
+func (sess *session) replyToExplain(ctx context.Context, log *trace, n github.PullRequestNotification, action state.Action, res operationResult) {
+	repo := n.Notification.Repository.GetFullName()
+	prNum := n.PullRequest.GetNumber()
+	user := sess.store.PR(repo, prNum).ExplainRequestedBy
+	if user == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	body := fmt.Sprintf("%s\n@%s treebot checked whether to %s this PR and the result was **%s**.", replyMarker, user, action, res)
+	if log.reason != "" {
+		body += fmt.Sprintf("\n\nReason: %s.", log.reason)
+	}
+	if _, err := sess.ghc.CreatePRComment(ctx, n, body); err != nil {
+		log.Warn(errors.Wrap(err, "replying to explain command"))
+		return
+	}
+
+	if err := sess.store.UpdatePR(repo, prNum, func(r *state.PRRecord) {
+		r.ExplainRequestedBy = ""
+	}); err != nil {
+		log.Warn(errors.Wrap(err, "clearing explain request in state store"))
+	}
+}
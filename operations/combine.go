@@ -0,0 +1,330 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	gogithub "github.com/google/go-github/v40/github"
+	"github.com/kimchelly/treebot-go/audit"
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	baseFlag           = "base"
+	minCombinedPRsFlag = "min-prs"
+)
+
+// combinedBranchPrefix is the prefix of the branches that treebot creates for
+// combined PRs.
+const combinedBranchPrefix = "treebot/combined-"
+
+// ruleCombineCommand is the rule that allows treebot to combine PRs.
+const ruleCombineCommand = "combine-command"
+
+func Combine() *cli.Command {
+	return &cli.Command{
+		Name:  "combine",
+		Usage: "combine the open bot PRs in a repo into a single PR",
+		Flags: append(sessionFlags(),
+			&cli.StringSliceFlag{
+				Name:     repoFlag,
+				Usage:    "repo(s) whose PRs should be combined in the form 'owner/repo'",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  baseFlag,
+				Usage: "only combine PRs against this base branch (default: the repo's default branch)",
+			},
+			&cli.IntFlag{
+				Name:  minCombinedPRsFlag,
+				Usage: "only open a combined PR if at least this many PRs can be combined",
+				Value: 2,
+			},
+		),
+		Action: func(c *cli.Context) error {
+			return combineBotPRs(c)
+		},
+	}
+}
+
+func combineBotPRs(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sess, err := newSession(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "setting up session")
+	}
+	defer sess.close()
+
+	sess.resolveCombinedPRs(ctx)
+
+	var failed []string
+	for _, repo := range c.StringSlice(repoFlag) {
+		log := zap.S().With("repo", repo)
+		if err := sess.combineRepoPRs(ctx, log, repo); err != nil {
+			log.Error(errors.Wrap(err, "combining PRs"))
+			failed = append(failed, repo)
+		}
+	}
+	logRateLimits(sess.ghc)
+
+	if len(failed) != 0 {
+		return errors.Errorf("could not combine PRs in repo(s): %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// combineRepoPRs merges the branches of the open bot PRs in the repo
+// into a new branch and opens a single PR for it. PRs that conflict with the
+// others are left out.
+func (sess *session) combineRepoPRs(ctx context.Context, log *zap.SugaredLogger, repo string) error {
+	owner, name := splitRepo(repo)
+	if owner == "" {
+		return errors.Errorf("repo '%s' should be in the form 'owner/repo'", repo)
+	}
+
+	base := sess.c.String(baseFlag)
+	if base == "" {
+		var err error
+		base, err = sess.ghc.GetDefaultBranch(ctx, owner, name)
+		if err != nil {
+			return errors.Wrap(err, "getting default branch")
+		}
+	}
+
+	candidates, err := sess.combineCandidates(ctx, log, repo, base)
+	if err != nil {
+		return errors.Wrap(err, "finding PRs to combine")
+	}
+	min := sess.c.Int(minCombinedPRsFlag)
+	if len(candidates) < min {
+		log.Infof("found %d PR(s) to combine against base branch '%s', but at least %d are needed", len(candidates), base, min)
+		return nil
+	}
+
+	if sess.c.Bool(interactiveFlag) {
+		fmt.Println()
+		for _, pr := range candidates {
+			fmt.Printf("#%d %s\n", pr.GetNumber(), pr.GetTitle())
+		}
+		yes, err := yesOrNo(fmt.Sprintf("Combine these %d PRs?", len(candidates)))
+		if err != nil {
+			return errors.Wrap(err, "asking user to combine PRs")
+		}
+		if !yes {
+			log.Info("user declined to combine the PRs")
+			return nil
+		}
+		fmt.Println()
+	}
+
+	head, err := sess.ghc.GetBranchHeadSHA(ctx, owner, name, base)
+	if err != nil {
+		return errors.Wrap(err, "getting head of base branch")
+	}
+	branch := combinedBranchPrefix + time.Now().UTC().Format("20060102-150405")
+	if err := sess.ghc.CreateBranch(ctx, owner, name, branch, head); err != nil {
+		return errors.Wrap(err, "creating combined branch")
+	}
+	// The combined branch is only kept if a combined PR is opened for it.
+	opened := false
+	defer func() {
+		if opened {
+			return
+		}
+		if err := sess.ghc.DeleteBranch(ctx, owner, name, branch); err != nil {
+			log.Warn(errors.Wrap(err, "cleaning up combined branch"))
+		}
+	}()
+
+	var included, conflicting []*gogithub.PullRequest
+	for _, pr := range candidates {
+		msg := fmt.Sprintf("Merge #%d: %s", pr.GetNumber(), pr.GetTitle())
+		_, err := sess.ghc.MergeBranch(ctx, owner, name, branch, pr.GetHead().GetSHA(), msg)
+		if err == github.ErrMergeConflict {
+			log.Warnw("leaving out PR that conflicts with the combined branch", "url", pr.GetHTMLURL())
+			conflicting = append(conflicting, pr)
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "merging PR #%d into combined branch", pr.GetNumber())
+		}
+		included = append(included, pr)
+	}
+
+	if len(included) < min {
+		log.Infof("only %d PR(s) could be combined without conflicts, but at least %d are needed", len(included), min)
+		return nil
+	}
+
+	title := fmt.Sprintf("Combine %d dependency updates", len(included))
+	combined, err := sess.ghc.CreatePR(ctx, owner, name, gogithub.NewPullRequest{
+		Title: gogithub.String(title),
+		Head:  gogithub.String(branch),
+		Base:  gogithub.String(base),
+		Body:  gogithub.String(combinedBody(included, conflicting)),
+	})
+	sess.appendAudit(log, audit.Entry{
+		Action: string(state.ActionCombine),
+		Repo:   repo,
+		Number: combined.GetNumber(),
+		URL:    combined.GetHTMLURL(),
+		Title:  title,
+		Rule:   ruleCombineCommand,
+	}, err)
+	if err != nil {
+		return errors.Wrap(err, "opening combined PR")
+	}
+	opened = true
+
+	cp := state.CombinedPR{
+		Number:    combined.GetNumber(),
+		Branch:    branch,
+		CreatedAt: time.Now(),
+	}
+	for _, pr := range included {
+		cp.Includes = append(cp.Includes, pr.GetNumber())
+	}
+	if err := sess.store.RecordCombinedPR(repo, cp); err != nil {
+		log.Warn(errors.Wrap(err, "recording combined PR in state store"))
+	}
+
+	log.Infow("opened combined PR",
+		"url", combined.GetHTMLURL(),
+		"included", len(included),
+		"conflicting", len(conflicting),
+	)
+	for _, pr := range conflicting {
+		log.Infow("PR was left out of the combined PR because of conflicts", "url", pr.GetHTMLURL(), "title", pr.GetTitle())
+	}
+
+	return nil
+}
+
+// combineCandidates returns the open bot PRs against the base branch
+// that can be combined. PRs that are held, that come from forks, that are
+// already part of an unresolved combined PR or that have commits that
+// auto-authorize wouldn't trust are left out, since the combined PR's CI runs
+// their commits without needing authorization.
+func (sess *session) combineCandidates(ctx context.Context, log *zap.SugaredLogger, repo, base string) ([]*gogithub.PullRequest, error) {
+	owner, name := splitRepo(repo)
+	var prs []*gogithub.PullRequest
+	for _, bot := range sess.bots {
+		botPRs, err := sess.ghc.ListOpenPRsByUser(ctx, owner, name, base, bot.Username)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing PRs from '%s'", bot.Username)
+		}
+		prs = append(prs, botPRs...)
+	}
+
+	alreadyCombined := map[int]bool{}
+	for _, cp := range sess.store.CombinedPRs()[repo] {
+		for _, num := range cp.Includes {
+			alreadyCombined[num] = true
+		}
+	}
+
+	var candidates []*gogithub.PullRequest
+	for _, pr := range prs {
+		prLog := newTrace(log.With("url", pr.GetHTMLURL()))
+		n := github.NewPullRequestNotification(*pr)
+		bot, ok := sess.checkBot(prLog, n)
+		if !ok {
+			continue
+		}
+		updates := sess.dependencyUpdates(ctx, prLog, n, bot)
+		if !sess.checkDependencyRules(prLog, n, updates) {
+			continue
+		}
+		switch {
+		case alreadyCombined[pr.GetNumber()]:
+			prLog.skipf("PR is already part of a combined PR")
+			continue
+		case pr.GetHead().GetRepo().GetFullName() != repo:
+			prLog.skipf("PR comes from a fork")
+			continue
+		case sess.checkHeld(prLog, n, updates):
+			continue
+		}
+		ok, err := sess.checkAuthorizableCommits(ctx, prLog, n, bot)
+		if err != nil {
+			prLog.Warn(errors.Wrap(err, "checking PR commits"))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, pr)
+	}
+	return candidates, nil
+}
+
+func combinedBody(included, conflicting []*gogithub.PullRequest) string {
+	var b strings.Builder
+	b.WriteString("This combines the following dependency update PRs:\n\n")
+	for _, pr := range included {
+		fmt.Fprintf(&b, "- #%d %s\n", pr.GetNumber(), pr.GetTitle())
+	}
+	if len(conflicting) != 0 {
+		b.WriteString("\nThese PRs were left out because they conflict with the others:\n\n")
+		for _, pr := range conflicting {
+			fmt.Fprintf(&b, "- #%d %s\n", pr.GetNumber(), pr.GetTitle())
+		}
+	}
+	b.WriteString("\nOnce this PR is merged, treebot will close the PRs that it combines.\n")
+	return b.String()
+}
+
+// resolveCombinedPRs closes the PRs that were combined into a combined PR
+// once it's merged. Combined PRs that were closed without merging are
+// forgotten so that their PRs can be combined again.
+func (sess *session) resolveCombinedPRs(ctx context.Context) {
+	for repo, cps := range sess.store.CombinedPRs() {
+		owner, name := splitRepo(repo)
+		for _, cp := range cps {
+			log := zap.S().With("repo", repo, "combined_pr", cp.Number)
+			if err := sess.resolveCombinedPR(ctx, log, owner, name, cp); err != nil {
+				log.Error(errors.Wrap(err, "resolving combined PR"))
+			}
+		}
+	}
+}
+
+func (sess *session) resolveCombinedPR(ctx context.Context, log *zap.SugaredLogger, owner, name string, cp state.CombinedPR) error {
+	repo := owner + "/" + name
+	combined, err := sess.ghc.GetPR(ctx, owner, name, cp.Number)
+	if err != nil {
+		return errors.Wrap(err, "getting combined PR")
+	}
+	if combined.GetState() != github.PRStateClosed {
+		return nil
+	}
+	if !combined.GetMerged() {
+		log.Info("combined PR was closed without being merged")
+		return sess.store.ForgetCombinedPR(repo, cp.Number)
+	}
+
+	for _, num := range cp.Includes {
+		pr, err := sess.ghc.GetPR(ctx, owner, name, num)
+		if err != nil {
+			return errors.Wrapf(err, "getting PR #%d", num)
+		}
+		if pr.GetState() != github.PRStateOpen {
+			continue
+		}
+		msg := fmt.Sprintf("The changes in this PR were merged in #%d.", cp.Number)
+		if err := sess.ghc.ClosePR(ctx, owner, name, num, msg); err != nil {
+			return errors.Wrapf(err, "closing PR #%d", num)
+		}
+		log.Infow("closed PR that was merged as part of combined PR", "url", pr.GetHTMLURL())
+	}
+
+	return sess.store.ForgetCombinedPR(repo, cp.Number)
+}
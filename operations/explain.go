@@ -0,0 +1,155 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+)
+
+func Explain() *cli.Command {
+	return &cli.Command{
+		Name:      "explain",
+		Usage:     "show every check that auto-authorize and auto-merge make on a PR and what they would decide, without acting on it",
+		ArgsUsage: "<pr-url>",
+		Flags:     autoGitHubFlags(),
+		Action: func(c *cli.Context) error {
+			return explainPR(c)
+		},
+	}
+}
+
+func explainPR(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("exactly one PR URL is required")
+	}
+	owner, repo, number, err := parsePRURL(c.Args().First())
+	if err != nil {
+		return errors.Wrap(err, "parsing PR URL")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sess, err := newSession(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "setting up session")
+	}
+	defer sess.close()
+
+	getPRCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	pr, err := sess.ghc.GetPR(getPRCtx, owner, repo, number)
+	if err != nil {
+		return errors.Wrap(err, "getting PR")
+	}
+	n := github.NewPullRequestNotification(*pr)
+
+	fmt.Printf("%s\n%s\n", pr.GetHTMLURL(), pr.GetTitle())
+	for _, action := range []state.Action{state.ActionAuthorize, state.ActionMerge} {
+		log := newExplainingTrace(zap.S().With("url", pr.GetHTMLURL(), "action", action))
+		if err := sess.explainAction(ctx, log, n, action); err != nil {
+			return errors.Wrapf(err, "explaining %s decision", action)
+		}
+		if err := printExplanation(action, log); err != nil {
+			return errors.Wrapf(err, "writing %s explanation", action)
+		}
+	}
+
+	return nil
+}
+
+// explainAction runs the same checks on the PR that auto-authorize or
+// auto-merge would, without acting on it. The title filters are checked here
+// because the commands only apply them when getting notifications.
+func (sess *session) explainAction(ctx context.Context, log *trace, n github.PullRequestNotification, action state.Action) error {
+	titles := sess.c.StringSlice(includeTitlesFlag)
+	if len(titles) == 0 {
+		log.notApplicable("title filter", "no --%s filters are set", includeTitlesFlag)
+	} else {
+		matched := ""
+		for _, expr := range titles {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return errors.Wrapf(err, "compiling title pattern '%s'", expr)
+			}
+			if re.MatchString(n.PullRequest.GetTitle()) {
+				matched = expr
+				break
+			}
+		}
+		if matched == "" {
+			log.failf("title filter", "title doesn't match any of the title filters (%s)", strings.Join(titles, ", "))
+			return nil
+		}
+		log.pass("title filter", "title matches '%s'", matched)
+	}
+
+	var err error
+	switch action {
+	case state.ActionAuthorize:
+		_, err = checkAndAuthorizeDependabotPR(ctx, sess, log, n)
+	case state.ActionMerge:
+		_, err = checkAndMergeDependabotPR(ctx, sess, log, n)
+	}
+	return err
+}
+
+func printExplanation(action state.Action, log *trace) error {
+	fmt.Printf("\n%s:\n", action)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, c := range log.checks {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", strings.ToUpper(c.outcome), c.name, c.evidence)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if c, ok := log.firstFailure(); ok {
+		fmt.Printf("decision: treebot would not %s this PR because %s; the checks after it were not made\n", action, c.evidence)
+	} else {
+		fmt.Printf("decision: treebot would %s this PR\n", action)
+	}
+	return nil
+}
+
+// prURLRegexp matches the short form of a PR reference, "owner/repo#123".
+var prURLRegexp = regexp.MustCompile(`^([^/\s]+)/([^/#\s]+)#(\d+)$`)
+
+// parsePRURL parses the owner, repo and number of a PR from its URL, such as
+// "https://github.com/owner/repo/pull/123", or from "owner/repo#123".
+func parsePRURL(s string) (owner, repo string, number int, err error) {
+	if m := prURLRegexp.FindStringSubmatch(s); m != nil {
+		number, err = strconv.Atoi(m[3])
+		return m[1], m[2], number, err
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", 0, err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 2; i < len(parts)-1; i++ {
+		if parts[i] != "pull" && parts[i] != "pulls" {
+			continue
+		}
+		number, err = strconv.Atoi(parts[i+1])
+		if err != nil {
+			return "", "", 0, errors.Wrap(err, "parsing PR number")
+		}
+		return parts[i-2], parts[i-1], number, nil
+	}
+	return "", "", 0, errors.Errorf("'%s' is not a PR URL", s)
+}
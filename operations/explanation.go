@@ -0,0 +1,116 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+)
+
+// explanationMarker identifies the PR comment that treebot uses to explain what
+// blocks it from acting on a PR.
+const explanationMarker = "<!-- treebot:explanation -->"
+
+// blocksAction returns whether the result means that something the PR's
+// owners should know about blocks the action.
+func blocksAction(res operationResult) bool {
+	switch res {
+	case skipped, held, frozen:
+		return true
+	default:
+		return false
+	}
+}
+
+// explainDecision labels and comments on bot PRs that treebot can't act on to
+// explain what blocks them. A PR has a single comment that lists what blocks
+// each action, which is updated in place whenever a check's outcome changes
+// what blocks the PR. The comment and the label are removed once nothing
+// blocks the PR or once it's merged.
+func (sess *session) explainDecision(ctx context.Context, log *trace, n github.PullRequestNotification, action state.Action, res operationResult) {
+	if !sess.c.Bool(explainSkipsFlag) || res == notBot {
+		return
+	}
+
+	repo := n.Notification.Repository.GetFullName()
+	prNum := n.PullRequest.GetNumber()
+	before := sess.store.PR(repo, prNum).Blocked
+
+	after := map[state.Action]string{}
+	for a, reason := range before {
+		after[a] = reason
+	}
+	merged := n.PullRequest.GetMerged() || (action == state.ActionMerge && res == done)
+	switch {
+	case merged:
+		after = map[state.Action]string{}
+	case blocksAction(res):
+		reason := log.reason
+		if reason == "" {
+			reason = "it did not meet the requirements"
+		}
+		after[action] = reason
+	default:
+		delete(after, action)
+	}
+	if len(before) == 0 && len(after) == 0 || reflect.DeepEqual(before, after) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	label := sess.c.String(blockedLabelFlag)
+	if len(after) == 0 {
+		if err := sess.ghc.DeletePRComment(ctx, n, explanationMarker); err != nil {
+			log.Warn(errors.Wrap(err, "deleting explanation comment"))
+			return
+		}
+		if label != "" && github.HasLabel(n.PullRequest, label) {
+			if err := sess.ghc.RemovePRLabel(ctx, n, label); err != nil {
+				log.Warn(errors.Wrap(err, "removing blocked label"))
+				return
+			}
+		}
+	} else {
+		if err := sess.ghc.UpsertPRComment(ctx, n, explanationMarker, explanationBody(after)); err != nil {
+			log.Warn(errors.Wrap(err, "updating explanation comment"))
+			return
+		}
+		if label != "" && !github.HasLabel(n.PullRequest, label) {
+			if err := sess.ghc.AddPRLabel(ctx, n, label); err != nil {
+				log.Warn(errors.Wrap(err, "adding blocked label"))
+				return
+			}
+		}
+	}
+
+	if err := sess.store.UpdatePR(repo, prNum, func(r *state.PRRecord) {
+		r.Blocked = after
+		if len(after) == 0 {
+			r.Blocked = nil
+		}
+	}); err != nil {
+		log.Warn(errors.Wrap(err, "recording explanation in state store"))
+	}
+}
+
+// explanationBody returns the body of the explanation comment for what blocks
+// each action on the PR.
+func explanationBody(blocked map[state.Action]string) string {
+	var b strings.Builder
+	b.WriteString(explanationMarker + "\n")
+	b.WriteString("treebot can't act on this PR right now:\n\n")
+	for _, a := range []state.Action{state.ActionAuthorize, state.ActionMerge} {
+		if reason, ok := blocked[a]; ok {
+			fmt.Fprintf(&b, "- treebot did not %s this PR because %s.\n", a, reason)
+		}
+	}
+	b.WriteString("\nThis comment is updated automatically whenever treebot checks this PR again.")
+	return b.String()
+}
@@ -0,0 +1,135 @@
+package operations
+
+import (
+	"strings"
+
+	"github.com/kimchelly/treebot-go/config"
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/pkg/errors"
+)
+
+func validateUpdateType(updateType string) error {
+	if updateType == "" {
+		return nil
+	}
+	for _, t := range github.UpdateTypes() {
+		if t == updateType {
+			return nil
+		}
+	}
+	return errors.Errorf("'%s' is not a valid update type", updateType)
+}
+
+// checkUpdateFilters checks that the dependency updates in the PR match the
+// package, directory and update type filters, if any are set. For grouped
+// updates, every member must match the package and directory filters, and the
+// largest update in the group must match the update type filter.
+func (sess *session) checkUpdateFilters(log *trace, updates []github.DependencyUpdate) bool {
+	packages := sess.c.StringSlice(includePackagesFlag)
+	directories := sess.c.StringSlice(includeDirectoriesFlag)
+	maxUpdateType := sess.c.String(maxUpdateTypeFlag)
+	if len(packages) == 0 && len(directories) == 0 && maxUpdateType == "" {
+		log.notApplicable("update filters", "no --%s, --%s or --%s filters are set", includePackagesFlag, includeDirectoriesFlag, maxUpdateTypeFlag)
+		return true
+	}
+
+	if len(updates) == 0 {
+		log.failf("update filters", "the dependency updates in the PR could not be determined, so the update filters can't be checked")
+		return false
+	}
+
+	for _, u := range updates {
+		if len(packages) != 0 && !containsString(packages, u.Name) {
+			log.failf("update filters", "dependency '%s' is not one of the included packages (%s)", u.Name, strings.Join(packages, ", "))
+			return false
+		}
+		dir := u.Directory
+		if dir == "" {
+			dir = "/"
+		}
+		if len(directories) != 0 && !containsString(directories, dir) {
+			log.failf("update filters", "dependency '%s' is in directory '%s', which is not one of the included directories (%s)", u.Name, dir, strings.Join(directories, ", "))
+			return false
+		}
+		if maxUpdateType != "" && u.UpdateType() == "" {
+			log.failf("update filters", "the update type of dependency '%s' could not be determined", formatUpdate(u))
+			return false
+		}
+	}
+
+	if maxUpdateType != "" {
+		updateType, _ := github.MaxUpdateType(updates)
+		if github.CompareUpdateTypes(updateType, maxUpdateType) > 0 {
+			var largest []string
+			for _, u := range updates {
+				if u.UpdateType() == updateType {
+					largest = append(largest, formatUpdate(u))
+				}
+			}
+			log.failf("update filters", "PR has a %s update (%s), but at most %s updates are allowed", updateType, strings.Join(largest, ", "), maxUpdateType)
+			return false
+		}
+	}
+
+	log.pass("update filters", "the updates match the package, directory and update type filters")
+	return true
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDependencyRules checks the PR's dependencies against the package rules
+// in the config and, unless every dependency is allowed by them, against the
+// update filters.
+func (sess *session) checkDependencyRules(log *trace, n github.PullRequestNotification, updates []github.DependencyUpdate) bool {
+	allowed, ok := sess.checkPackageRules(log, n, updates)
+	if !ok {
+		return false
+	}
+	if allowed {
+		log.notApplicable("update filters", "every dependency matches a package allow rule")
+		return true
+	}
+	return sess.checkUpdateFilters(log, updates)
+}
+
+// checkPackageRules checks the PR's dependencies against the package allow and
+// deny rules in the config. It returns false if any dependency is denied, and
+// whether every dependency is allowed, in which case the update filters don't
+// apply.
+func (sess *session) checkPackageRules(log *trace, n github.PullRequestNotification, updates []github.DependencyUpdate) (allowed bool, ok bool) {
+	repo := n.Notification.Repository.GetFullName()
+	deps := dependencyNames(updates)
+	if len(deps) == 0 {
+		if sess.conf.HasDenyRules(repo) {
+			log.failf("package rules", "the dependencies in the PR could not be determined, so the package deny rules can't be checked")
+			return false, false
+		}
+		log.notApplicable("package rules", "the dependencies in the PR could not be determined, but there are no package deny rules")
+		return false, true
+	}
+
+	allowed = true
+	for _, dep := range deps {
+		rule, pattern, ok := sess.conf.PackageRule(repo, dep)
+		if rule == config.PackageDenied {
+			log.failf("package rules", "dependency '%s' matches the package deny rule '%s'", dep, pattern)
+			return false, false
+		}
+		if !ok {
+			allowed = false
+		}
+	}
+	if allowed {
+		log.pass("package rules", "every dependency matches a package allow rule")
+	} else {
+		log.pass("package rules", "no dependency matches a package deny rule")
+	}
+	return allowed, true
+}
@@ -0,0 +1,102 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/pkg/errors"
+)
+
+// mainlinePollInterval is how often to check the mainline build status while
+// waiting for it to finish.
+const mainlinePollInterval = 30 * time.Second
+
+// mainlineGate remembers which repos have a failing mainline build so that
+// treebot stops merging into them for the rest of the run.
+type mainlineGate struct {
+	mu      sync.Mutex
+	failing map[string]string
+}
+
+func newMainlineGate() *mainlineGate {
+	return &mainlineGate{failing: map[string]string{}}
+}
+
+func (g *mainlineGate) failure(repo string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	reason, ok := g.failing[repo]
+	return reason, ok
+}
+
+func (g *mainlineGate) fail(repo, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.failing[repo] = reason
+}
+
+// waitForMainline waits for the build of the PR's base branch to succeed
+// before merging into it, and records whether the PR can be merged into it in
+// the trace. A head commit with no statuses counts as an unfinished build.
+// When only explaining, it doesn't wait for an unfinished build.
+func (sess *session) waitForMainline(ctx context.Context, log *trace, n github.PullRequestNotification) (bool, error) {
+	if !sess.c.Bool(waitForMainlineFlag) {
+		log.notApplicable("mainline", "--%s is not set", waitForMainlineFlag)
+		return true, nil
+	}
+
+	repo := n.Notification.Repository.GetFullName()
+	if reason, ok := sess.mainline.failure(repo); ok {
+		log.failf("mainline", "%s", reason)
+		return false, nil
+	}
+
+	branch := n.PullRequest.GetBase().GetRef()
+	timeout := time.After(sess.c.Duration(mainlineTimeoutFlag))
+	for {
+		statusCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		status, err := sess.ghc.GetBranchCombinedStatus(statusCtx, n.Notification, branch)
+		cancel()
+		if err != nil {
+			return false, errors.Wrapf(err, "getting status of base branch '%s'", branch)
+		}
+
+		sha := shortSHA(status.GetSHA())
+		switch {
+		case status.GetTotalCount() == 0:
+			// The build may not have reported yet, especially right after a
+			// merge, so no statuses is treated like a pending build.
+			log.Debugf("base branch '%s' has no statuses on its head commit '%s' yet", branch, sha)
+		case status.GetState() == github.CombinedStatusSuccess:
+			log.pass("mainline", "the mainline build for base branch '%s' succeeded at commit '%s'", branch, sha)
+			return true, nil
+		case status.GetState() != github.CombinedStatusPending:
+			reason := fmt.Sprintf("the mainline build for base branch '%s' is '%s' at commit '%s', so no more PRs will be merged into this repo in this run", branch, status.GetState(), sha)
+			if !log.explaining {
+				sess.mainline.fail(repo, reason)
+			}
+			log.failf("mainline", "%s", reason)
+			return false, nil
+		}
+
+		if log.explaining {
+			log.failf("mainline", "the mainline build for base branch '%s' at commit '%s' has not finished, so treebot would wait up to %s for it", branch, sha, sess.c.Duration(mainlineTimeoutFlag))
+			return false, nil
+		}
+
+		log.Infof("waiting for the mainline build for base branch '%s' at commit '%s' to finish", branch, sha)
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-timeout:
+			log.failf("mainline", "the mainline build for base branch '%s' at commit '%s' did not finish within %s", branch, sha, sess.c.Duration(mainlineTimeoutFlag))
+			return false, nil
+		case <-time.After(mainlinePollInterval):
+		}
+	}
+}
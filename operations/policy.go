@@ -0,0 +1,177 @@
+package operations
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	gogithub "github.com/google/go-github/v40/github"
+	"github.com/kimchelly/treebot-go/config"
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/policy"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// policyRule returns the audit rule for an action that a policy allowed.
+func policyRule(name string) string {
+	return "policy:" + name
+}
+
+// checkPolicies evaluates the policies in the config for the action on the PR.
+// It returns the name of the policy that allowed the action, if any.
+func (sess *session) checkPolicies(log *trace, n github.PullRequestNotification, action state.Action, bot github.BotProfile, updates []github.DependencyUpdate, statuses []gogithub.RepoStatus) (string, bool) {
+	if sess.policies.Empty(action) {
+		log.notApplicable("policies", "no policies apply to %s", action)
+		return "", true
+	}
+
+	d, err := sess.policies.Evaluate(action, policyInput(n, bot, updates, statuses))
+	if err != nil {
+		log.failf("policies", "the policies could not be evaluated: %s", err)
+		return "", false
+	}
+	if !d.Allowed {
+		log.failf("policies", "%s", d.Reason)
+		return "", false
+	}
+	log.pass("policies", "%s", d.Reason)
+	log.Infof("%s is allowed because %s", action, d.Reason)
+	return d.Policy, true
+}
+
+// policyInput describes the PR in the policies' object model.
+func policyInput(n github.PullRequestNotification, bot github.BotProfile, updates []github.DependencyUpdate, statuses []gogithub.RepoStatus) policy.Input {
+	pr := n.PullRequest
+	in := policy.Input{
+		Repo:      n.Notification.Repository.GetFullName(),
+		Bot:       bot.Name,
+		Ecosystem: bot.Ecosystem(pr),
+		PR: policy.PR{
+			Number:         pr.GetNumber(),
+			Title:          pr.GetTitle(),
+			Body:           pr.GetBody(),
+			URL:            pr.GetHTMLURL(),
+			Author:         pr.GetUser().GetLogin(),
+			Branch:         pr.GetHead().GetRef(),
+			Base:           pr.GetBase().GetRef(),
+			Draft:          pr.GetDraft(),
+			Commits:        pr.GetCommits(),
+			MergeableState: pr.GetMergeableState(),
+			Age:            sinceOrZero(pr.GetCreatedAt()),
+			UpdatedAge:     sinceOrZero(pr.GetUpdatedAt()),
+		},
+		Notification: policy.Notification{
+			Reason: n.Notification.GetReason(),
+			Unread: n.Notification.GetUnread(),
+			Age:    sinceOrZero(n.Notification.GetUpdatedAt()),
+		},
+		Status: github.CombinedState(statuses),
+	}
+	in.UpdateType, _ = github.MaxUpdateType(updates)
+	for _, u := range updates {
+		in.Updates = append(in.Updates, policy.Update{
+			Name:      u.Name,
+			From:      u.From,
+			To:        u.To,
+			Type:      u.UpdateType(),
+			Directory: u.Directory,
+		})
+	}
+	for _, l := range pr.Labels {
+		in.PR.Labels = append(in.PR.Labels, l.GetName())
+	}
+	for _, s := range statuses {
+		in.Statuses = append(in.Statuses, policy.Status{
+			Context:     s.GetContext(),
+			State:       s.GetState(),
+			Description: s.GetDescription(),
+		})
+	}
+	return in
+}
+
+func sinceOrZero(t time.Time) time.Duration {
+	if t.IsZero() {
+		return 0
+	}
+	return time.Since(t)
+}
+
+func Policy() *cli.Command {
+	return &cli.Command{
+		Name:  "policy",
+		Usage: "work with the policies in the config file",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "test",
+				Usage:     "evaluate the policies against saved fixtures and check that they make the expected decisions",
+				ArgsUsage: "<fixture file>...",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     configFlag,
+						Usage:    "path to the YAML configuration file",
+						Required: true,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return testPolicies(c)
+				},
+			},
+		},
+	}
+}
+
+func testPolicies(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("at least one fixture file is required")
+	}
+
+	conf, err := config.Load(c.String(configFlag))
+	if err != nil {
+		return errors.Wrap(err, "loading config")
+	}
+	policies, err := policy.NewSet(conf.Policies)
+	if err != nil {
+		return errors.Wrap(err, "compiling policies")
+	}
+
+	var total, failed int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RESULT\tFIXTURE\tACTION\tEXPECTED\tDECISION")
+	for _, path := range c.Args().Slice() {
+		fixtures, err := policy.LoadFixtures(path)
+		if err != nil {
+			return errors.Wrapf(err, "loading fixtures from '%s'", path)
+		}
+		for _, fx := range fixtures {
+			total++
+			expected := fx.Expect
+			if fx.Policy != "" {
+				expected += fmt.Sprintf(" by '%s'", fx.Policy)
+			}
+
+			result := "PASS"
+			d, passed, err := policies.Test(fx)
+			decision := d.Reason
+			if err != nil {
+				decision = err.Error()
+			}
+			if !passed {
+				result = "FAIL"
+				failed++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result, fx.Name, fx.Action, expected, decision)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "writing results")
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d of %d fixture(s) failed", failed, total)
+	}
+	return nil
+}
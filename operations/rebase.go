@@ -0,0 +1,102 @@
+package operations
+
+import (
+	"context"
+	"time"
+
+	"github.com/kimchelly/treebot-go/audit"
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+)
+
+// ruleMergeConflicts is the rule that allows treebot to ask a bot to resolve
+// a PR's merge conflicts.
+const ruleMergeConflicts = "bot-merge-conflicts"
+
+// requestRebase asks the bot to rebase a PR with merge conflicts. If the PR
+// still has conflicts after it was rebased a few times, it asks the bot to
+// recreate the PR instead. Only one request is made for each head commit,
+// unless the bot doesn't push anything in time.
+func (sess *session) requestRebase(ctx context.Context, log *trace, n github.PullRequestNotification, bot github.BotProfile) error {
+	if !sess.c.Bool(requestRebaseFlag) || bot.RebaseCommand == "" {
+		log.failf("mergeable", "PR has merge conflicts")
+		return nil
+	}
+
+	repo := n.Notification.Repository.GetFullName()
+	pr := n.PullRequest
+	headSHA := pr.GetHead().GetSHA()
+
+	var requests int
+	if prev := sess.store.PR(repo, pr.GetNumber()).Rebase; prev != nil {
+		if prev.HeadSHA == headSHA && time.Since(prev.At) < sess.c.Duration(rebaseTimeoutFlag) {
+			log.failf("mergeable", "PR has merge conflicts and treebot is waiting for '%s' to respond to '%s' from %s", bot.Username, prev.Command, prev.At.Format(time.RFC1123))
+			return nil
+		}
+		requests = prev.Requests
+	}
+
+	max := sess.c.Int(maxRebaseRequestsFlag)
+	command := bot.RebaseCommand
+	switch {
+	case requests > max, requests == max && bot.RecreateCommand == "":
+		log.failf("mergeable", "PR has merge conflicts that '%s' did not resolve after %d requests", bot.Username, requests)
+		return nil
+	case requests == max:
+		command = bot.RecreateCommand
+	}
+	if log.explaining {
+		log.failf("mergeable", "PR has merge conflicts, so treebot would ask '%s' to resolve them with '%s'", bot.Username, command)
+		return nil
+	}
+
+	commentCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	_, err := sess.ghc.CreatePRComment(commentCtx, n, command)
+	sess.appendAudit(log.SugaredLogger, audit.Entry{
+		Action:  string(state.ActionRebase),
+		Repo:    repo,
+		Number:  pr.GetNumber(),
+		URL:     github.GetHumanReadableURL(n),
+		Title:   pr.GetTitle(),
+		HeadSHA: headSHA,
+		Rule:    ruleMergeConflicts,
+	}, err)
+	if err != nil {
+		return errors.Wrapf(err, "posting '%s'", command)
+	}
+
+	if err := sess.store.UpdatePR(repo, pr.GetNumber(), func(r *state.PRRecord) {
+		r.Rebase = &state.RebaseRequest{
+			Command:  command,
+			HeadSHA:  headSHA,
+			At:       time.Now(),
+			Requests: requests + 1,
+		}
+	}); err != nil {
+		log.Warn(errors.Wrap(err, "recording rebase request in state store"))
+	}
+
+	log.failf("mergeable", "PR has merge conflicts, so treebot asked '%s' to resolve them with '%s'", bot.Username, command)
+	return nil
+}
+
+// clearRebase forgets about requests to resolve the PR's merge conflicts once
+// the PR is mergeable again.
+func (sess *session) clearRebase(log *trace, n github.PullRequestNotification) {
+	if log.explaining {
+		return
+	}
+	repo := n.Notification.Repository.GetFullName()
+	num := n.PullRequest.GetNumber()
+	if sess.store.PR(repo, num).Rebase == nil {
+		return
+	}
+	if err := sess.store.UpdatePR(repo, num, func(r *state.PRRecord) {
+		r.Rebase = nil
+	}); err != nil {
+		log.Warn(errors.Wrap(err, "clearing rebase request in state store"))
+	}
+}
@@ -0,0 +1,389 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	gogithub "github.com/google/go-github/v40/github"
+	"github.com/kimchelly/treebot-go/audit"
+	"github.com/kimchelly/treebot-go/config"
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/policy"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+)
+
+// session is the state shared by all the PR checks in a single run.
+type session struct {
+	c     *cli.Context
+	ghc   *github.Client
+	store *state.Store
+	// auditLog records every mutating action. It is nil if auditing is
+	// disabled.
+	auditLog *audit.Log
+	// actor is the GitHub user whose credentials are used for all actions.
+	actor string
+	conf  *config.Config
+	// mergeBudget limits how many PRs can be merged.
+	mergeBudget *mergeBudget
+	// mainline tracks repos whose mainline build is failing.
+	mainline *mainlineGate
+	// bots are the profiles of the bots whose PRs treebot acts on.
+	bots []github.BotProfile
+	// policies are the policies from the config that decide whether PRs can
+	// be authorized or merged.
+	policies *policy.Set
+}
+
+func newSession(ctx context.Context, c *cli.Context) (*session, error) {
+	// Commands that don't act on notifications, such as combine, don't have
+	// these flags.
+	if hasFlag(c, markNotificationsFlag) {
+		if err := validateMarkNotificationsMode(c.String(markNotificationsFlag)); err != nil {
+			return nil, errors.Wrapf(err, "invalid flag '%s'", markNotificationsFlag)
+		}
+	}
+	if hasFlag(c, updateBehindFlag) {
+		if err := validateUpdateBehindMode(c.String(updateBehindFlag)); err != nil {
+			return nil, errors.Wrapf(err, "invalid flag '%s'", updateBehindFlag)
+		}
+	}
+	if err := validateUpdateType(c.String(maxUpdateTypeFlag)); err != nil {
+		return nil, errors.Wrapf(err, "invalid flag '%s'", maxUpdateTypeFlag)
+	}
+
+	conf, err := config.Load(c.String(configFlag))
+	if err != nil {
+		return nil, errors.Wrap(err, "loading config")
+	}
+
+	bots, err := botProfiles(c.StringSlice(botsFlag), conf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid flag '%s'", botsFlag)
+	}
+
+	policies, err := policy.NewSet(conf.Policies)
+	if err != nil {
+		return nil, errors.Wrap(err, "compiling policies")
+	}
+
+	ghc, err := newGitHubClient(ctx, c)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating GitHub client")
+	}
+
+	actor, err := ghc.GetAuthenticatedUser(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting acting GitHub user")
+	}
+
+	store, err := state.Open(c.String(stateFileFlag))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening state store")
+	}
+
+	var auditLog *audit.Log
+	if path := c.String(auditLogFlag); path != "" {
+		auditLog, err = audit.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening audit log")
+		}
+	}
+
+	return &session{
+		c:        c,
+		ghc:      ghc,
+		store:    store,
+		auditLog: auditLog,
+		actor:    actor,
+		conf:     conf,
+		mergeBudget: newMergeBudget(
+			c.Int(maxMergesPerRunFlag),
+			c.Int(maxMergesPerRepoPerRunFlag),
+			c.Int(maxMergesPerRepoPerDayFlag),
+			store,
+		),
+		mainline: newMainlineGate(),
+		bots:     bots,
+		policies: policies,
+	}, nil
+}
+
+// hasFlag returns whether the command defines the flag.
+func hasFlag(c *cli.Context, name string) bool {
+	for _, f := range c.Command.Flags {
+		for _, n := range f.Names() {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (sess *session) close() {
+	if sess.auditLog == nil {
+		return
+	}
+	if err := sess.auditLog.Close(); err != nil {
+		zap.S().Warn(errors.Wrap(err, "closing audit log"))
+	}
+}
+
+func defaultStateFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "treebot", "state.json")
+}
+
+func defaultAuditLog() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "treebot", "audit.jsonl")
+}
+
+// Names of the rules that allow treebot to act on a PR.
+const (
+	ruleDependabotManualAuthorization = "dependabot-manual-authorization"
+	ruleDependabotChecksPassed        = "dependabot-checks-passed"
+	ruleForcedByCommand               = "forced-by-command"
+)
+
+// forcedBy returns the user who forced the action on the PR's current head
+// commit with a command, if any.
+func (sess *session) forcedBy(n github.PullRequestNotification, action state.Action) string {
+	repo := n.Notification.Repository.GetFullName()
+	record := sess.store.PR(repo, n.PullRequest.GetNumber())
+	return record.ForcedBy(action, n.PullRequest.GetHead().GetSHA())
+}
+
+// actionRecord describes an attempted action on a PR.
+type actionRecord struct {
+	action state.Action
+	// rule is the rule that allowed the action.
+	rule string
+	// statuses are the commit statuses that were considered when deciding to
+	// take the action.
+	statuses []gogithub.RepoStatus
+	// updates are the dependency updates in the PR.
+	updates []github.DependencyUpdate
+}
+
+// recordAction records an attempted action on the PR's head commit in the
+// state store and audit log. Failing to record the action is not fatal, since
+// the action itself already happened.
+func (sess *session) recordAction(log *trace, n github.PullRequestNotification, rec actionRecord, actionErr error) {
+	repo := n.Notification.Repository.GetFullName()
+	pr := n.PullRequest
+	if err := sess.store.RecordAction(repo, pr.GetNumber(), pr.GetHead().GetSHA(), rec.action, actionErr); err != nil {
+		log.Warn(errors.Wrapf(err, "recording %s action in state store", rec.action))
+	}
+
+	entry := audit.Entry{
+		Action:  string(rec.action),
+		Repo:    repo,
+		Number:  pr.GetNumber(),
+		URL:     github.GetHumanReadableURL(n),
+		Title:   pr.GetTitle(),
+		HeadSHA: pr.GetHead().GetSHA(),
+		Rule:    rec.rule,
+	}
+	for _, s := range rec.statuses {
+		entry.Statuses = append(entry.Statuses, auditStatus(s))
+	}
+	for _, u := range rec.updates {
+		entry.Updates = append(entry.Updates, auditUpdate(u))
+	}
+	sess.appendAudit(log.SugaredLogger, entry, actionErr)
+}
+
+// appendAudit fills in the common fields of the audit entry for an attempted
+// action and writes it to the audit log, if auditing is enabled.
+func (sess *session) appendAudit(log *zap.SugaredLogger, entry audit.Entry, actionErr error) {
+	if sess.auditLog == nil {
+		return
+	}
+
+	entry.Time = time.Now()
+	entry.Actor = sess.actor
+	entry.Interactive = sess.c.Bool(interactiveFlag)
+	entry.Result = audit.ResultSuccess
+	if actionErr != nil {
+		entry.Result = audit.ResultFailure
+		entry.Error = actionErr.Error()
+	}
+	if err := sess.auditLog.Append(entry); err != nil {
+		log.Error(errors.Wrapf(err, "writing %s action to audit log", entry.Action))
+	}
+}
+
+func auditStatus(s gogithub.RepoStatus) audit.Status {
+	return audit.Status{
+		Context:     s.GetContext(),
+		State:       s.GetState(),
+		Description: s.GetDescription(),
+		TargetURL:   s.GetTargetURL(),
+	}
+}
+
+func auditUpdate(u github.DependencyUpdate) audit.Update {
+	return audit.Update{
+		Name:      u.Name,
+		From:      u.From,
+		To:        u.To,
+		Type:      u.UpdateType(),
+		Directory: u.Directory,
+	}
+}
+
+// checkHeld checks whether a user put the PR on hold, either by labeling it
+// with one of the hold labels or with a command, or whether updates of the
+// PR's dependency are held.
+func (sess *session) checkHeld(log *trace, n github.PullRequestNotification, updates []github.DependencyUpdate) bool {
+	for _, label := range sess.c.StringSlice(holdLabelsFlag) {
+		if github.HasLabel(n.PullRequest, label) {
+			log.failf("hold", "PR has hold label '%s'", label)
+			return true
+		}
+	}
+
+	repo := n.Notification.Repository.GetFullName()
+	if hold := sess.store.PR(repo, n.PullRequest.GetNumber()).Hold; hold != nil {
+		log.failf("hold", "user '%s' put the PR on hold with '%s %s'", hold.By, commandPrefix, commandHold)
+		return true
+	}
+
+	if dependency, hold, ok := sess.heldDependency(repo, updates); ok {
+		log.failf("hold", "updates of dependency '%s' are held until %s because %s", dependency, hold.Until.Format(time.RFC1123), hold.Reason)
+		return true
+	}
+
+	log.pass("hold", "neither the PR nor its dependencies are on hold")
+	return false
+}
+
+// heldDependency returns the first of the updated dependencies whose updates
+// are held in the repo.
+func (sess *session) heldDependency(repo string, updates []github.DependencyUpdate) (string, state.DependencyHold, bool) {
+	for _, dependency := range dependencyNames(updates) {
+		if hold, ok := sess.store.DependencyHeld(repo, dependency, time.Now()); ok {
+			return dependency, hold, true
+		}
+	}
+	return "", state.DependencyHold{}, false
+}
+
+// freezeReason checks whether merges into the PR's repo are currently frozen
+// and if so, returns the reason.
+func (sess *session) freezeReason(n github.PullRequestNotification) (string, bool) {
+	repo := n.Notification.Repository.GetFullName()
+	w, end, ok := sess.conf.ActiveFreeze(repo, time.Now())
+	if !ok {
+		return "", false
+	}
+
+	name := w.Name
+	if name == "" {
+		name = "unnamed"
+	}
+	return fmt.Sprintf("merges are frozen by the '%s' freeze window until %s", name, end.Format(time.RFC1123)), true
+}
+
+// checkFreeze checks whether merges into the PR's repo are currently frozen.
+// PRs can still be authorized during a freeze if --authorize-during-freeze is
+// set, so that their CI results are ready when the freeze ends.
+func (sess *session) checkFreeze(log *trace, n github.PullRequestNotification, action state.Action) bool {
+	reason, frozen := sess.freezeReason(n)
+	switch {
+	case !frozen:
+		log.pass("freeze", "no merge freeze is active")
+		return true
+	case action == state.ActionAuthorize && sess.c.Bool(authorizeInFreezeFlag):
+		log.pass("freeze", "%s, but PRs are authorized during freezes so that their CI results are ready when the freeze ends", reason)
+		return true
+	default:
+		log.failf("freeze", "%s", reason)
+		return false
+	}
+}
+
+// checkHistory checks whether the action can be attempted on the PR's head
+// commit based on what treebot remembers about the PR. The action can't be
+// attempted if it was already completed for the head commit or if it has
+// failed too many times.
+func (sess *session) checkHistory(log *trace, n github.PullRequestNotification, action state.Action) (operationResult, bool) {
+	repo := n.Notification.Repository.GetFullName()
+	pr := n.PullRequest
+	headSHA := pr.GetHead().GetSHA()
+	record := sess.store.PR(repo, pr.GetNumber())
+
+	if record.IsCompleted(action, headSHA) {
+		log.failf("history", "%s action was already completed for head commit '%s'", action, headSHA)
+		return alreadyDone, false
+	}
+	if failures := record.FailuresFor(action, headSHA); failures >= sess.c.Int(maxAttemptsFlag) {
+		log.failf("history", "%s action already failed %d times for head commit '%s'", action, failures, headSHA)
+		return skipped, false
+	}
+
+	log.pass("history", "%s was not already completed or failed too many times for head commit '%s'", action, shortSHA(headSHA))
+	return "", true
+}
+
+// Ways to mark notifications for resolved PRs.
+const (
+	markNotificationsNone = "none"
+	markNotificationsRead = "read"
+	markNotificationsDone = "done"
+)
+
+func markNotificationsModes() []string {
+	return []string{markNotificationsNone, markNotificationsRead, markNotificationsDone}
+}
+
+func validateMarkNotificationsMode(mode string) error {
+	for _, m := range markNotificationsModes() {
+		if m == mode {
+			return nil
+		}
+	}
+	return errors.Errorf("'%s' is not a valid way to mark notifications", mode)
+}
+
+// resolveNotification marks the notification as read or done if treebot
+// finished with the bot PR, which is once it's merged or closed. Authorized
+// PRs still need to be merged, so their notifications are left alone for
+// auto-merge to find, as are notifications for PRs with any other result,
+// including PRs that weren't opened by a bot, so that humans still see them.
+func (sess *session) resolveNotification(ctx context.Context, log *trace, n github.PullRequestNotification, action state.Action, res operationResult) {
+	if res != done && res != alreadyDone {
+		return
+	}
+	closed := n.PullRequest.GetState() == github.PRStateClosed || n.PullRequest.GetMerged()
+	if !closed && action != state.ActionMerge {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	switch sess.c.String(markNotificationsFlag) {
+	case markNotificationsRead:
+		if err := sess.ghc.MarkNotificationRead(ctx, n.Notification); err != nil {
+			log.Warn(errors.Wrap(err, "marking notification as read"))
+		}
+	case markNotificationsDone:
+		if err := sess.ghc.MarkNotificationDone(ctx, n.Notification); err != nil {
+			log.Warn(errors.Wrap(err, "marking notification as done"))
+		}
+	}
+}
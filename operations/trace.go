@@ -0,0 +1,81 @@
+package operations
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// trace is the logger used while checking a single PR. It also remembers the
+// reason for the decision made about the PR and the outcome of each check.
+type trace struct {
+	*zap.SugaredLogger
+	reason string
+	checks []checkResult
+	// explaining is set when the checks are only made to explain what
+	// treebot would do, in which case nothing is changed.
+	explaining bool
+}
+
+func newTrace(log *zap.SugaredLogger) *trace {
+	return &trace{SugaredLogger: log}
+}
+
+// newExplainingTrace returns a trace for explaining what treebot would do with
+// a PR without changing anything.
+func newExplainingTrace(log *zap.SugaredLogger) *trace {
+	return &trace{SugaredLogger: log, explaining: true}
+}
+
+// skipf records why no action was taken on the PR.
+func (t *trace) skipf(format string, args ...interface{}) {
+	t.reason = fmt.Sprintf(format, args...)
+	t.Debugf("skipping because %s", t.reason)
+}
+
+// Outcomes of a check.
+const (
+	checkPassed        = "pass"
+	checkFailed        = "fail"
+	checkNotApplicable = "n/a"
+)
+
+// checkResult is the outcome of a single check made on a PR, along with the
+// evidence for it.
+type checkResult struct {
+	name     string
+	outcome  string
+	evidence string
+}
+
+// pass records that the check passed.
+func (t *trace) pass(name, format string, args ...interface{}) {
+	t.record(name, checkPassed, fmt.Sprintf(format, args...))
+}
+
+// failf records that the check failed and why, which is also the reason why
+// no action was taken on the PR.
+func (t *trace) failf(name, format string, args ...interface{}) {
+	t.skipf(format, args...)
+	t.record(name, checkFailed, t.reason)
+}
+
+// notApplicable records that the check doesn't apply to the PR.
+func (t *trace) notApplicable(name, format string, args ...interface{}) {
+	t.record(name, checkNotApplicable, fmt.Sprintf(format, args...))
+}
+
+func (t *trace) record(name, outcome, evidence string) {
+	t.checks = append(t.checks, checkResult{name: name, outcome: outcome, evidence: evidence})
+	t.Debugw("checked PR", "check", name, "outcome", outcome, "evidence", evidence)
+}
+
+// firstFailure returns the first check that failed, if any.
+func (t *trace) firstFailure() (checkResult, bool) {
+	for _, c := range t.checks {
+		if c.outcome == checkFailed {
+			return c, true
+		}
+	}
+	return checkResult{}, false
+}
@@ -0,0 +1,117 @@
+package operations
+
+import (
+	"context"
+	"time"
+
+	"github.com/kimchelly/treebot-go/audit"
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+)
+
+// Ways to bring PRs that are behind their base branch up to date.
+const (
+	updateBehindNone   = "none"
+	updateBehindBranch = "update-branch"
+	updateBehindRebase = "rebase"
+)
+
+func updateBehindModes() []string {
+	return []string{updateBehindNone, updateBehindBranch, updateBehindRebase}
+}
+
+func validateUpdateBehindMode(mode string) error {
+	for _, m := range updateBehindModes() {
+		if m == mode {
+			return nil
+		}
+	}
+	return errors.Errorf("'%s' is not a valid way to update PRs that are behind", mode)
+}
+
+// ruleBranchBehind is the rule that allows treebot to update a PR that is
+// behind its base branch.
+const ruleBranchBehind = "branch-behind-base"
+
+// branchUpdateTimeout is how long to wait for a branch update to show up on
+// the PR before updating it again.
+const branchUpdateTimeout = time.Hour
+
+// updateBehindPR brings a PR that is behind its base branch up to date. The
+// PR can be merged on a later run once the patch for its new head commit
+// finishes.
+func (sess *session) updateBehindPR(ctx context.Context, log *trace, n github.PullRequestNotification, bot github.BotProfile) (operationResult, error) {
+	mode := sess.c.String(updateBehindFlag)
+	if mode == updateBehindNone || (mode == updateBehindRebase && bot.RebaseCommand == "") {
+		log.failf("mergeable", "PR is behind its base branch")
+		return skipped, nil
+	}
+
+	repo := n.Notification.Repository.GetFullName()
+	pr := n.PullRequest
+	headSHA := pr.GetHead().GetSHA()
+	if prev := sess.store.PR(repo, pr.GetNumber()).BranchUpdate; prev != nil && prev.FromSHA == headSHA && time.Since(prev.At) < branchUpdateTimeout {
+		log.failf("mergeable", "PR is behind its base branch and treebot is waiting for the branch update from %s", prev.At.Format(time.RFC1123))
+		return deferred, nil
+	}
+	if log.explaining {
+		log.failf("mergeable", "PR is behind its base branch, so treebot would update it with '%s'", mode)
+		return deferred, nil
+	}
+
+	updateCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	entry := audit.Entry{
+		Action:  string(state.ActionUpdateBranch),
+		Repo:    repo,
+		Number:  pr.GetNumber(),
+		URL:     github.GetHumanReadableURL(n),
+		Title:   pr.GetTitle(),
+		HeadSHA: headSHA,
+		Rule:    ruleBranchBehind,
+	}
+	var err error
+	switch mode {
+	case updateBehindBranch:
+		var outcome github.UpdateBranchOutcome
+		outcome, err = sess.ghc.UpdatePRFromNotification(updateCtx, n)
+		switch outcome {
+		case github.UpdateBranchUpToDate:
+			log.failf("mergeable", "PR was behind its base branch, but its branch is already up to date")
+			return deferred, nil
+		case github.UpdateBranchConflict, github.UpdateBranchPermission:
+			sess.appendAudit(log.SugaredLogger, entry, err)
+			log.failf("mergeable", "PR is behind its base branch, but its branch could not be updated (%s)", outcome)
+			return skipped, nil
+		}
+	case updateBehindRebase:
+		entry.Action = string(state.ActionRebase)
+		_, err = sess.ghc.CreatePRComment(updateCtx, n, bot.RebaseCommand)
+	}
+	sess.appendAudit(log.SugaredLogger, entry, err)
+	if err != nil {
+		return errored, errors.Wrapf(err, "updating PR with '%s'", mode)
+	}
+	sess.recordBranchUpdate(log, n, mode)
+
+	log.failf("mergeable", "PR was behind its base branch, so treebot updated it with '%s'; it can be merged once the new patch finishes", mode)
+	return deferred, nil
+}
+
+// recordBranchUpdate remembers that treebot updated the PR's branch so that
+// the update isn't repeated while GitHub is still applying it.
+func (sess *session) recordBranchUpdate(log *trace, n github.PullRequestNotification, method string) {
+	repo := n.Notification.Repository.GetFullName()
+	pr := n.PullRequest
+	if err := sess.store.UpdatePR(repo, pr.GetNumber(), func(r *state.PRRecord) {
+		r.BranchUpdate = &state.BranchUpdate{
+			Method:  method,
+			FromSHA: pr.GetHead().GetSHA(),
+			At:      time.Now(),
+		}
+	}); err != nil {
+		log.Warn(errors.Wrap(err, "recording branch update in state store"))
+	}
+}
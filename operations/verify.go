@@ -0,0 +1,269 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	gogithub "github.com/google/go-github/v40/github"
+	"github.com/kimchelly/treebot-go/audit"
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ruleMainlineBroken is the rule that allows treebot to revert a merged
+// commit.
+const ruleMainlineBroken = "mainline-broken-by-merge"
+
+// verifyMergedCommits checks the mainline builds of the commits that treebot
+// merged in previous runs. If a merged commit broke a passing build, the
+// dependency it updated is held and, optionally, a PR is opened to revert it.
+func (sess *session) verifyMergedCommits(ctx context.Context) {
+	if !sess.c.Bool(verifyMergesFlag) {
+		return
+	}
+
+	for repo, commits := range sess.store.UnverifiedCommits() {
+		for _, mc := range commits {
+			log := zap.S().With("repo", repo, "sha", mc.SHA, "pr", mc.PRNumber)
+			if err := sess.verifyMergedCommit(ctx, log, repo, mc); err != nil {
+				log.Error(errors.Wrap(err, "verifying merged commit"))
+			}
+		}
+	}
+}
+
+func (sess *session) verifyMergedCommit(ctx context.Context, log *zap.SugaredLogger, repo string, mc state.MergedCommit) error {
+	owner, name := splitRepo(repo)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	status, err := sess.ghc.GetCommitCombinedStatus(ctx, owner, name, mc.SHA)
+	if err != nil {
+		return errors.Wrap(err, "getting status of merged commit")
+	}
+	switch {
+	case status.GetTotalCount() == 0:
+		// The build may not have reported any statuses yet, so only give up on
+		// it once it's had plenty of time to.
+		if time.Since(mc.MergedAt) < sess.c.Duration(verifyTimeoutFlag) {
+			log.Debug("mainline build of merged commit has not reported any statuses yet")
+			return nil
+		}
+		log.Warnf("mainline build of merged commit reported no statuses within %s, so it can't be verified", sess.c.Duration(verifyTimeoutFlag))
+		return sess.setVerification(repo, mc.SHA, state.VerificationNoStatuses, 0)
+	case status.GetState() == github.CombinedStatusSuccess:
+		log.Debug("mainline build of merged commit passed")
+		return sess.setVerification(repo, mc.SHA, state.VerificationPassed, 0)
+	case status.GetState() == github.CombinedStatusPending:
+		log.Debug("mainline build of merged commit has not finished yet")
+		return nil
+	}
+
+	parents, err := sess.ghc.GetCommitParents(ctx, owner, name, mc.SHA)
+	if err != nil {
+		return errors.Wrap(err, "getting parents of merged commit")
+	}
+	if len(parents) != 1 {
+		log.Warnf("mainline build of merged commit is '%s', but it has %d parents so it won't be reverted", status.GetState(), len(parents))
+		return sess.setVerification(repo, mc.SHA, state.VerificationFailed, 0)
+	}
+	baseline, baselineSHA, err := sess.baselineStatus(ctx, owner, name, parents[0])
+	if err != nil {
+		return errors.Wrap(err, "getting status of commits before merged commit")
+	}
+	switch {
+	case baseline == nil:
+		log.Warnf("mainline build of merged commit is '%s', but none of the %d commits before it have statuses to compare it with, so it won't be reverted", status.GetState(), maxBaselineCommits)
+		return sess.setVerification(repo, mc.SHA, state.VerificationInconclusive, 0)
+	case baseline.GetState() == github.CombinedStatusPending:
+		if time.Since(mc.MergedAt) < sess.c.Duration(verifyTimeoutFlag) {
+			log.Debugf("mainline build of merged commit is '%s', but the build of commit '%s' before it has not finished yet", status.GetState(), shortSHA(baselineSHA))
+			return nil
+		}
+		log.Warnf("mainline build of merged commit is '%s', but the build of commit '%s' before it did not finish within %s, so it won't be reverted", status.GetState(), shortSHA(baselineSHA), sess.c.Duration(verifyTimeoutFlag))
+		return sess.setVerification(repo, mc.SHA, state.VerificationInconclusive, 0)
+	case baseline.GetState() != github.CombinedStatusSuccess:
+		log.Infof("mainline build of merged commit is '%s', but the build of commit '%s' before it was already '%s'", status.GetState(), shortSHA(baselineSHA), baseline.GetState())
+		return sess.setVerification(repo, mc.SHA, state.VerificationPreexisting, 0)
+	}
+
+	log.Warnf("merging PR #%d '%s' broke the mainline build of branch '%s'", mc.PRNumber, mc.Title, mc.Branch)
+
+	mc.Dependencies = sess.mergedDependencies(mc)
+	until := time.Now().Add(sess.c.Duration(revertHoldFlag))
+	for _, dependency := range mc.Dependencies {
+		if err := sess.store.HoldDependency(repo, dependency, state.DependencyHold{
+			Reason: fmt.Sprintf("merging PR #%d broke the mainline build at commit '%s'", mc.PRNumber, shortSHA(mc.SHA)),
+			Until:  until,
+		}); err != nil {
+			return errors.Wrapf(err, "holding dependency '%s'", dependency)
+		}
+		log.Infof("holding updates of dependency '%s' until %s", dependency, until.Format(time.RFC1123))
+	}
+
+	if !sess.c.Bool(revertFailedMergesFlag) {
+		return sess.setVerification(repo, mc.SHA, state.VerificationFailed, 0)
+	}
+
+	var failing []gogithub.RepoStatus
+	for _, s := range status.Statuses {
+		if s.GetState() != github.CommitStatusSuccess && s.GetState() != github.CommitStatusPending {
+			failing = append(failing, *s)
+		}
+	}
+
+	pr, err := sess.ghc.CreateRevertPR(ctx, github.RevertOptions{
+		Owner:  owner,
+		Repo:   name,
+		SHA:    mc.SHA,
+		Base:   mc.Branch,
+		Branch: "treebot/revert-" + shortSHA(mc.SHA),
+		Title:  fmt.Sprintf("Revert \"%s\"", mc.Title),
+		Body:   revertBody(mc, failing, until),
+	})
+	sess.auditRevert(log, repo, mc, pr, failing, err)
+	if cause := errors.Cause(err); cause == github.ErrRevertConflict || cause == github.ErrRevertTooLarge {
+		log.Warn(errors.Wrap(err, "not reverting merged commit"))
+		return sess.setVerification(repo, mc.SHA, state.VerificationFailed, 0)
+	}
+	if err != nil {
+		return errors.Wrap(err, "opening revert PR")
+	}
+
+	log.Infow("opened revert PR", "url", pr.GetHTMLURL())
+
+	return sess.setVerification(repo, mc.SHA, state.VerificationFailed, pr.GetNumber())
+}
+
+// maxBaselineCommits is how many commits before a merged commit are checked
+// for a mainline build to compare the merged commit's build with.
+const maxBaselineCommits = 10
+
+// baselineStatus returns the combined status of the closest commit at or
+// before the given one that has any statuses, following first parents, along
+// with that commit's SHA. It returns a nil status if none of the first
+// maxBaselineCommits commits have statuses.
+func (sess *session) baselineStatus(ctx context.Context, owner, name, sha string) (*gogithub.CombinedStatus, string, error) {
+	for i := 0; i < maxBaselineCommits; i++ {
+		status, err := sess.ghc.GetCommitCombinedStatus(ctx, owner, name, sha)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "getting status of commit '%s'", shortSHA(sha))
+		}
+		if status.GetTotalCount() != 0 {
+			return status, sha, nil
+		}
+
+		parents, err := sess.ghc.GetCommitParents(ctx, owner, name, sha)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "getting parents of commit '%s'", shortSHA(sha))
+		}
+		if len(parents) == 0 {
+			break
+		}
+		sha = parents[0]
+	}
+	return nil, "", nil
+}
+
+// mergedDependencies returns the names of the dependencies that the merged PR
+// updated. Commits that were merged before the dependencies were recorded only
+// have the PR's title, so the dependencies are parsed from it instead.
+func (sess *session) mergedDependencies(mc state.MergedCommit) []string {
+	if len(mc.Dependencies) != 0 {
+		return mc.Dependencies
+	}
+	for _, bot := range sess.bots {
+		if updates := bot.UpdatesFromTitle(mc.Title); len(updates) != 0 {
+			return dependencyNames(updates)
+		}
+	}
+	return nil
+}
+
+// closeHeldUpdate closes the PR if it updates a dependency whose updates are
+// held because a merge of it was reverted. Once the revert is merged, the bot
+// would propose the same update again, but neither Dependabot nor Renovate
+// reopen an update whose PR was closed without merging it.
+func (sess *session) closeHeldUpdate(ctx context.Context, log *trace, n github.PullRequestNotification, bot github.BotProfile, updates []github.DependencyUpdate) error {
+	if log.explaining || !sess.c.Bool(revertFailedMergesFlag) {
+		return nil
+	}
+	repo := n.Notification.Repository.GetFullName()
+	dependency, hold, ok := sess.heldDependency(repo, updates)
+	if !ok {
+		return nil
+	}
+
+	if sess.c.Bool(interactiveFlag) {
+		yes, err := yesOrNo(fmt.Sprintf("Close this PR because updates of '%s' are held?", dependency))
+		if err != nil {
+			return errors.Wrap(err, "asking user to close PR")
+		}
+		if !yes {
+			return nil
+		}
+	}
+
+	comment := fmt.Sprintf("Closing this PR because updates of `%s` are held until %s because %s. Closing it stops %s from proposing this update again.", dependency, hold.Until.Format(time.RFC1123), hold.Reason, bot.Name)
+	owner, name := splitRepo(repo)
+	if err := sess.ghc.ClosePR(ctx, owner, name, n.PullRequest.GetNumber(), comment); err != nil {
+		return errors.Wrap(err, "closing PR for held dependency")
+	}
+	log.Infof("closed PR for held dependency '%s'", dependency)
+	return nil
+}
+
+func (sess *session) setVerification(repo, sha, verification string, revertPR int) error {
+	return sess.store.UpdateMergedCommit(repo, sha, func(mc *state.MergedCommit) {
+		mc.Verification = verification
+		mc.RevertPR = revertPR
+	})
+}
+
+// auditRevert records an attempt to revert a merged commit in the audit log.
+func (sess *session) auditRevert(log *zap.SugaredLogger, repo string, mc state.MergedCommit, pr *gogithub.PullRequest, failing []gogithub.RepoStatus, revertErr error) {
+	entry := audit.Entry{
+		Action:  string(state.ActionRevert),
+		Repo:    repo,
+		Number:  mc.PRNumber,
+		URL:     pr.GetHTMLURL(),
+		Title:   mc.Title,
+		HeadSHA: mc.SHA,
+		Rule:    ruleMainlineBroken,
+	}
+	for _, s := range failing {
+		entry.Statuses = append(entry.Statuses, auditStatus(s))
+	}
+	sess.appendAudit(log, entry, revertErr)
+}
+
+func revertBody(mc state.MergedCommit, failing []gogithub.RepoStatus, until time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "This reverts #%d (commit %s), which broke the mainline build of `%s`. The build of the commit before it was passing.\n\n", mc.PRNumber, mc.SHA, mc.Branch)
+	b.WriteString("Failing statuses:\n")
+	for _, s := range failing {
+		if s.GetTargetURL() != "" {
+			fmt.Fprintf(&b, "- [%s](%s): %s\n", s.GetContext(), s.GetTargetURL(), s.GetDescription())
+		} else {
+			fmt.Fprintf(&b, "- %s: %s\n", s.GetContext(), s.GetDescription())
+		}
+	}
+	if len(mc.Dependencies) != 0 {
+		fmt.Fprintf(&b, "\nUpdates of `%s` are held and won't be acted on by treebot until %s.\n", strings.Join(mc.Dependencies, "`, `"), until.Format(time.RFC1123))
+	}
+	return b.String()
+}
+
+// splitRepo splits a full repo name into its owner and name.
+func splitRepo(fullName string) (string, string) {
+	i := strings.Index(fullName, "/")
+	if i < 0 {
+		return "", fullName
+	}
+	return fullName[:i], fullName[i+1:]
+}
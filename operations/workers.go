@@ -0,0 +1,118 @@
+package operations
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kimchelly/treebot-go/github"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// checkFunc checks a single PR notification and acts on it if appropriate.
+type checkFunc func(ctx context.Context, sess *session, log *trace, n github.PullRequestNotification) (operationResult, error)
+
+// processNotifications runs the check on all the notifications and returns
+// the notifications grouped by the result of the check.
+func processNotifications(ctx context.Context, sess *session, notifications []github.PullRequestNotification, action state.Action, check checkFunc) map[operationResult][]github.PullRequestNotification {
+	workers := sess.c.Int(parallelismFlag)
+	if sess.c.Bool(interactiveFlag) {
+		// Interactive prompts read from stdin, so only one PR can be handled
+		// at a time.
+		workers = 1
+	}
+
+	results := make([]operationResult, len(notifications))
+	processNotificationsByRepo(notifications, workers, func(i int, n github.PullRequestNotification) {
+		log := newTrace(zap.S().With("url", github.GetHumanReadableURL(n)))
+		log.Infof("Notification #%d: %s", i+1, github.GetLogFormat(n.Notification))
+
+		repo := n.Notification.Repository.GetFullName()
+		prNum := n.PullRequest.GetNumber()
+		if err := sess.store.ObservePR(repo, prNum, n.PullRequest.GetHead().GetSHA()); err != nil {
+			log.Warn(errors.Wrap(err, "recording PR in state store"))
+		}
+
+		if err := sess.handleCommands(ctx, log, n); err != nil {
+			log.Warn(errors.Wrap(err, "handling commands in PR comments"))
+		}
+
+		res, err := check(ctx, sess, log, n)
+		if err != nil {
+			log.Error(errors.Wrapf(err, "checking and %s Dependabot PR from notification", actionVerb(action)))
+			res = errored
+		}
+		results[i] = res
+
+		if err := sess.store.RecordResult(repo, prNum, string(res)); err != nil {
+			log.Warn(errors.Wrap(err, "recording result in state store"))
+		}
+
+		sess.resolveNotification(ctx, log, n, action, res)
+		sess.explainDecision(ctx, log, n, action, res)
+		sess.replyToExplain(ctx, log, n, action, res)
+	})
+
+	byResult := map[operationResult][]github.PullRequestNotification{}
+	for i, res := range results {
+		byResult[res] = append(byResult[res], notifications[i])
+	}
+
+	return byResult
+}
+
+// actionVerb returns the present participle of the action for messages.
+func actionVerb(action state.Action) string {
+	switch action {
+	case state.ActionAuthorize:
+		return "authorizing"
+	case state.ActionMerge:
+		return "merging"
+	default:
+		return string(action)
+	}
+}
+
+// processNotificationsByRepo calls process for every notification using up to
+// the given number of concurrent workers. Notifications for PRs in different
+// repos may be processed concurrently, but notifications for PRs in the same
+// repo are always processed one at a time in their original order, since
+// acting on one PR (e.g. merging it) can change the state of other PRs in the
+// same repo.
+func processNotificationsByRepo(notifications []github.PullRequestNotification, workers int, process func(i int, n github.PullRequestNotification)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var repos []string
+	byRepo := map[string][]int{}
+	for i, n := range notifications {
+		repo := n.Notification.Repository.GetFullName()
+		if _, ok := byRepo[repo]; !ok {
+			repos = append(repos, repo)
+		}
+		byRepo[repo] = append(byRepo[repo], i)
+	}
+
+	queue := make(chan []int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for indexes := range queue {
+				for _, i := range indexes {
+					process(i, notifications[i])
+				}
+			}
+		}()
+	}
+
+	for _, repo := range repos {
+		queue <- byRepo[repo]
+	}
+	close(queue)
+
+	wg.Wait()
+}
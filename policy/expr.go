@@ -0,0 +1,746 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Expr is a compiled policy expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses the expression.
+func Compile(src string) (*Expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, errors.Errorf("position %d: unexpected '%s'", tok.pos+1, tok.text)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the expression's source.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression, which must result in a boolean, with the
+// given variables.
+func (e *Expr) Eval(vars map[string]interface{}) (bool, error) {
+	v, err := e.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, errors.Errorf("expression must result in a boolean, but it resulted in %s", typeName(v))
+	}
+	return b, nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokenEOF {
+		p.i++
+	}
+	return tok
+}
+
+// accept consumes the next token if it's the given operator or keyword.
+func (p *parser) accept(text string) bool {
+	tok := p.peek()
+	if (tok.kind == tokenOp || tok.kind == tokenIdent) && tok.text == text {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		tok := p.peek()
+		if tok.kind == tokenEOF {
+			return errors.Errorf("position %d: expected '%s' but the expression ended", tok.pos+1, text)
+		}
+		return errors.Errorf("position %d: expected '%s' but got '%s'", tok.pos+1, text, tok.text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.accept("||") {
+			return l, nil
+		}
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "||", l: l, r: r, pos: pos}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseRelation()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.accept("&&") {
+			return l, nil
+		}
+		r, err := p.parseRelation()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "&&", l: l, r: r, pos: pos}
+	}
+}
+
+func (p *parser) parseRelation() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	for _, op := range []string{"==", "!=", "<", "<=", ">", ">=", "in"} {
+		if p.accept(op) {
+			r, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &binaryNode{op: op, l: l, r: r, pos: tok.pos}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	tok := p.peek()
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryNode{op: op, x: x, pos: tok.pos}, nil
+		}
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokenIdent {
+				return nil, errors.Errorf("position %d: expected a field or method name after '.'", name.pos+1)
+			}
+			if p.peek().kind == tokenOp && p.peek().text == "(" {
+				x, err = p.parseCall(x, name)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			x = &fieldNode{x: x, name: name.text, pos: name.pos}
+		case p.accept("["):
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexNode{x: x, index: index, pos: tok.pos}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// parseCall parses the arguments of a function or method call. The all() and
+// exists() methods are macros whose first argument is the name of the
+// variable that the second argument is evaluated with.
+func (p *parser) parseCall(target node, name token) (node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	if target != nil && (name.text == "all" || name.text == "exists") {
+		v := p.next()
+		if v.kind != tokenIdent {
+			return nil, errors.Errorf("position %d: expected a variable name as the first argument of %s()", v.pos+1, name.text)
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		body, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &macroNode{list: target, name: name.text, variable: v.text, body: body, pos: name.pos}, nil
+	}
+
+	var args []node
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	call := &callNode{target: target, name: name.text, args: args, pos: name.pos}
+	if err := call.compilePattern(); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString:
+		return &literalNode{v: tok.text}, nil
+	case tokenNumber:
+		return &literalNode{v: tok.num}, nil
+	case tokenDuration:
+		return &literalNode{v: tok.dur}, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{v: true}, nil
+		case "false":
+			return &literalNode{v: false}, nil
+		}
+		if p.peek().kind == tokenOp && p.peek().text == "(" {
+			return p.parseCall(nil, tok)
+		}
+		return &identNode{name: tok.text, pos: tok.pos}, nil
+	case tokenOp:
+		switch tok.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			list := &listNode{}
+			if p.accept("]") {
+				return list, nil
+			}
+			for {
+				elem, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				list.elems = append(list.elems, elem)
+				if p.accept("]") {
+					return list, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case tokenEOF:
+		return nil, errors.Errorf("position %d: unexpected end of expression", tok.pos+1)
+	}
+	return nil, errors.Errorf("position %d: unexpected '%s'", tok.pos+1, tok.text)
+}
+
+// node is a node in the expression's syntax tree. Values are bools, float64
+// numbers, strings, durations, lists ([]interface{}) and objects
+// (map[string]interface{}).
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	v interface{}
+}
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.v, nil
+}
+
+type identNode struct {
+	name string
+	pos  int
+}
+
+func (n *identNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return nil, errors.Errorf("position %d: unknown variable '%s'", n.pos+1, n.name)
+	}
+	return known(v, n.pos)
+}
+
+type listNode struct {
+	elems []node
+}
+
+func (n *listNode) eval(vars map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, 0, len(n.elems))
+	for _, elem := range n.elems {
+		v, err := elem.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+type fieldNode struct {
+	x    node
+	name string
+	pos  int
+}
+
+func (n *fieldNode) eval(vars map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := x.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("position %d: %s has no field '%s'", n.pos+1, typeName(x), n.name)
+	}
+	v, ok := obj[n.name]
+	if !ok {
+		return nil, errors.Errorf("position %d: object has no field '%s'", n.pos+1, n.name)
+	}
+	return known(v, n.pos)
+}
+
+type indexNode struct {
+	x     node
+	index node
+	pos   int
+}
+
+func (n *indexNode) eval(vars map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case []interface{}:
+		i, ok := index.(float64)
+		if !ok || i != float64(int(i)) {
+			return nil, errors.Errorf("position %d: list index must be an integer", n.pos+1)
+		}
+		if i < 0 || int(i) >= len(x) {
+			return nil, errors.Errorf("position %d: list index %d is out of range", n.pos+1, int(i))
+		}
+		return x[int(i)], nil
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, errors.Errorf("position %d: object key must be a string", n.pos+1)
+		}
+		v, ok := x[key]
+		if !ok {
+			return nil, errors.Errorf("position %d: object has no field '%s'", n.pos+1, key)
+		}
+		return known(v, n.pos)
+	default:
+		return nil, errors.Errorf("position %d: cannot index %s", n.pos+1, typeName(x))
+	}
+}
+
+type unaryNode struct {
+	op  string
+	x   node
+	pos int
+}
+
+func (n *unaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case bool:
+		if n.op == "!" {
+			return !x, nil
+		}
+	case float64:
+		if n.op == "-" {
+			return -x, nil
+		}
+	case time.Duration:
+		if n.op == "-" {
+			return -x, nil
+		}
+	}
+	return nil, errors.Errorf("position %d: cannot apply '%s' to %s", n.pos+1, n.op, typeName(x))
+}
+
+type binaryNode struct {
+	op   string
+	l, r node
+	pos  int
+}
+
+func (n *binaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := n.l.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "&&" || n.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, errors.Errorf("position %d: '%s' requires booleans, but got %s", n.pos+1, n.op, typeName(l))
+		}
+		if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+			return lb, nil
+		}
+		r, err := n.r.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, errors.Errorf("position %d: '%s' requires booleans, but got %s", n.pos+1, n.op, typeName(r))
+		}
+		return rb, nil
+	}
+
+	r, err := n.r.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		switch r := r.(type) {
+		case []interface{}:
+			for _, elem := range r {
+				if equal(l, elem) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			key, ok := l.(string)
+			if !ok {
+				return false, nil
+			}
+			_, ok = r[key]
+			return ok, nil
+		default:
+			return nil, errors.Errorf("position %d: 'in' requires a list or object, but got %s", n.pos+1, typeName(r))
+		}
+	default:
+		cmp, err := compare(l, r)
+		if err != nil {
+			return nil, errors.Errorf("position %d: %s", n.pos+1, err)
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	}
+}
+
+type callNode struct {
+	// target is the value that the method is called on, or nil for a
+	// function.
+	target node
+	name   string
+	args   []node
+	pos    int
+	// re is the compiled pattern of a matches() call whose pattern is a
+	// literal.
+	re *regexp.Regexp
+}
+
+// compilePattern compiles the pattern of a matches() call if it's a literal,
+// so that an invalid pattern is reported when the expression is compiled.
+func (n *callNode) compilePattern() error {
+	if n.name != "matches" || len(n.args) == 0 {
+		return nil
+	}
+	lit, ok := n.args[len(n.args)-1].(*literalNode)
+	if !ok {
+		return nil
+	}
+	pattern, ok := lit.v.(string)
+	if !ok {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return errors.Errorf("position %d: matches(): invalid pattern: %s", n.pos+1, err)
+	}
+	n.re = re
+	return nil
+}
+
+func (n *callNode) eval(vars map[string]interface{}) (interface{}, error) {
+	var args []interface{}
+	if n.target != nil {
+		target, err := n.target.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, target)
+	}
+	for _, arg := range n.args {
+		v, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	fn, ok := functions[n.name]
+	if n.re != nil {
+		fn = stringFunction(func(s, _ string) (bool, error) {
+			return n.re.MatchString(s), nil
+		})
+	}
+	if !ok {
+		return nil, errors.Errorf("position %d: unknown function '%s'", n.pos+1, n.name)
+	}
+	v, err := fn(args)
+	if err != nil {
+		return nil, errors.Errorf("position %d: %s(): %s", n.pos+1, n.name, err)
+	}
+	return v, nil
+}
+
+type macroNode struct {
+	list     node
+	name     string
+	variable string
+	body     node
+	pos      int
+}
+
+func (n *macroNode) eval(vars map[string]interface{}) (interface{}, error) {
+	x, err := n.list.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := x.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("position %d: %s() requires a list, but got %s", n.pos+1, n.name, typeName(x))
+	}
+
+	scope := make(map[string]interface{}, len(vars)+1)
+	for k, v := range vars {
+		scope[k] = v
+	}
+	for _, elem := range list {
+		scope[n.variable] = elem
+		v, err := n.body.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.Errorf("position %d: %s() requires a boolean condition, but got %s", n.pos+1, n.name, typeName(v))
+		}
+		if n.name == "all" && !b {
+			return false, nil
+		}
+		if n.name == "exists" && b {
+			return true, nil
+		}
+	}
+	return n.name == "all", nil
+}
+
+// functions are the built-in functions. Methods receive the value that they
+// are called on as their first argument.
+var functions = map[string]func(args []interface{}) (interface{}, error){
+	"size": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("expected 1 argument")
+		}
+		switch x := args[0].(type) {
+		case string:
+			return float64(len(x)), nil
+		case []interface{}:
+			return float64(len(x)), nil
+		case map[string]interface{}:
+			return float64(len(x)), nil
+		default:
+			return nil, errors.Errorf("cannot get the size of %s", typeName(x))
+		}
+	},
+	"contains":   stringFunction(infallible(strings.Contains)),
+	"startsWith": stringFunction(infallible(strings.HasPrefix)),
+	"endsWith":   stringFunction(infallible(strings.HasSuffix)),
+	"matches": stringFunction(func(s, pattern string) (bool, error) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, errors.Errorf("invalid pattern: %s", err)
+		}
+		return re.MatchString(s), nil
+	}),
+	"lower": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("expected 1 argument")
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, errors.Errorf("expected a string, but got %s", typeName(args[0]))
+		}
+		return strings.ToLower(s), nil
+	},
+}
+
+// infallible adapts a string predicate that can't fail for stringFunction.
+func infallible(fn func(s, arg string) bool) func(s, arg string) (bool, error) {
+	return func(s, arg string) (bool, error) {
+		return fn(s, arg), nil
+	}
+}
+
+func stringFunction(fn func(s, arg string) (bool, error)) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, errors.New("expected a string and 1 argument")
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, errors.Errorf("expected a string, but got %s", typeName(args[0]))
+		}
+		arg, ok := args[1].(string)
+		if !ok {
+			return nil, errors.Errorf("expected a string argument, but got %s", typeName(args[1]))
+		}
+		return fn(s, arg)
+	}
+}
+
+// known returns an error if the value is unknown.
+func known(v interface{}, pos int) (interface{}, error) {
+	if u, ok := v.(unknown); ok {
+		return nil, errors.Errorf("position %d: %s is unknown", pos+1, u.what)
+	}
+	return v, nil
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// compare returns a negative number if a < b, a positive number if a > b and
+// zero if they're equal.
+func compare(a, b interface{}) (int, error) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			return sign(a - b), nil
+		}
+	case time.Duration:
+		if b, ok := b.(time.Duration); ok {
+			return sign(float64(a - b)), nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	}
+	return 0, errors.Errorf("cannot compare %s with %s", typeName(a), typeName(b))
+}
+
+func sign(f float64) int {
+	switch {
+	case f < 0:
+		return -1
+	case f > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case time.Duration:
+		return "a duration"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	case nil:
+		return "nothing"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
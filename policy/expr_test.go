@@ -0,0 +1,210 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+// testVars are the variables that the expressions in the tests are evaluated
+// with.
+func testVars() map[string]interface{} {
+	return Input{
+		Repo:       "mongodb/evergreen",
+		Bot:        "dependabot",
+		Ecosystem:  "gomod",
+		UpdateType: "minor",
+		Updates: []Update{
+			{Name: "golang.org/x/net", From: "0.1.0", To: "0.2.0", Type: "minor"},
+			{Name: "golang.org/x/sys", From: "0.1.0", To: "0.1.1", Type: "patch"},
+		},
+		PR: PR{
+			Number: 42,
+			Title:  "Bump the go group with 2 updates",
+			Labels: []string{"dependencies", "go"},
+			Age:    72 * time.Hour,
+		},
+		Status: "success",
+	}.vars()
+}
+
+func TestEval(t *testing.T) {
+	for src, expected := range map[string]bool{
+		// Literals and comparisons.
+		`true`:                                  true,
+		`!false`:                                true,
+		`1 < 2`:                                 true,
+		`2 <= 2`:                                true,
+		`-1 > 0`:                                false,
+		`"a" < "b"`:                             true,
+		`48h == 2d`:                             true,
+		`90m > 1h`:                              true,
+		`-1h < 0h`:                              true,
+		`repo == "mongodb/evergreen"`:           true,
+		`bot != "renovate"`:                     true,
+		`pr.number == 42`:                       true,
+		`pr.age > 48h`:                          true,
+		`pr.age > 7d`:                           false,
+		`pr["number"] == 42`:                    true,
+		`updates[1].name == "golang.org/x/sys"`: true,
+
+		// Precedence: ! binds tighter than comparisons, which bind tighter
+		// than &&, which binds tighter than ||.
+		`true || false && false`:   true,
+		`(true || false) && false`: false,
+		`!true || true`:            true,
+		`!(true || true)`:          false,
+		`(1 < 2) == true`:          true,
+		`false && false || true`:   true,
+
+		// Membership.
+		`repo in ["mongodb/evergreen", "mongodb/mongo"]`: true,
+		`ecosystem in ["npm", "pip"]`:                    false,
+		`"go" in pr.labels`:                              true,
+		`"number" in pr`:                                 true,
+		`1 in []`:                                        false,
+
+		// Functions and methods.
+		`size(updates) == 2`:                                    true,
+		`size(pr.labels) == 2 && size("abc") == 3`:              true,
+		`pr.title.contains("go group")`:                         true,
+		`pr.title.startsWith("Bump")`:                           true,
+		`pr.title.endsWith("updates")`:                          true,
+		`pr.title.matches("^Bump the \\w+ group")`:              true,
+		`matches(repo, "^mongodb/")`:                            true,
+		`lower(pr.title) == "bump the go group with 2 updates"`: true,
+
+		// Macros.
+		`updates.all(u, u.type != "major")`:                   true,
+		`updates.all(u, u.type == "patch")`:                   false,
+		`updates.exists(u, u.type == "patch")`:                true,
+		`updates.exists(u, u.name.startsWith("github.com/"))`: false,
+		`[].all(x, x)`:    true,
+		`[].exists(x, x)`: false,
+		`updates.exists(u, u.name == "golang.org/x/net" && repo.startsWith("mongodb"))`: true,
+	} {
+		e, err := Compile(src)
+		if err != nil {
+			t.Errorf("Compile(%s): unexpected error: %s", src, err)
+			continue
+		}
+		v, err := e.Eval(testVars())
+		if err != nil {
+			t.Errorf("Eval(%s): unexpected error: %s", src, err)
+			continue
+		}
+		if v != expected {
+			t.Errorf("Eval(%s): got %t, expected %t", src, v, expected)
+		}
+	}
+}
+
+func TestEvalShortCircuits(t *testing.T) {
+	// The right-hand sides refer to a variable that doesn't exist, so they
+	// would fail if they were evaluated.
+	for src, expected := range map[string]bool{
+		`false && missing`: false,
+		`true || missing`:  true,
+		`updates.all(u, u.type == "patch" && missing)`:    false,
+		`updates.exists(u, u.type == "minor" || missing)`: true,
+	} {
+		e, err := Compile(src)
+		if err != nil {
+			t.Errorf("Compile(%s): unexpected error: %s", src, err)
+			continue
+		}
+		v, err := e.Eval(testVars())
+		if err != nil {
+			t.Errorf("Eval(%s): unexpected error: %s", src, err)
+			continue
+		}
+		if v != expected {
+			t.Errorf("Eval(%s): got %t, expected %t", src, v, expected)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`a &&`,
+		`(a`,
+		`[1, 2`,
+		`a b`,
+		`a.`,
+		`a.1`,
+		`updates.all(1, true)`,
+		`updates.exists(u true)`,
+		`size(1,`,
+		// Comparisons don't chain.
+		`1 < 2 == true`,
+		`pr.title.matches("(")`,
+		`matches(repo, "[")`,
+	} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("Compile(%q): expected an error", src)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, src := range []string{
+		`missing`,
+		`pr.missing == 1`,
+		`1`,
+		`"a" && true`,
+		`false || "a"`,
+		`!1`,
+		`-"a"`,
+		`1 < "a"`,
+		`1 in 2`,
+		`updates[2].name == ""`,
+		`updates["a"] == ""`,
+		`size(1) == 1`,
+		`unknownFunction()`,
+		`repo.contains(1)`,
+		`updates.all(u, u.name)`,
+		`repo.all(c, true)`,
+		// A pattern that isn't a literal is compiled when it's evaluated.
+		`repo.matches(lower("("))`,
+	} {
+		e, err := Compile(src)
+		if err != nil {
+			t.Errorf("Compile(%s): unexpected error: %s", src, err)
+			continue
+		}
+		if _, err := e.Eval(testVars()); err == nil {
+			t.Errorf("Eval(%s): expected an error", src)
+		}
+	}
+}
+
+func TestEvalUnknownValues(t *testing.T) {
+	vars := Input{
+		Updates: []Update{{Name: "github.com/x/y", From: "abc123", To: "def456"}},
+	}.vars()
+	for _, src := range []string{
+		`updateType != "major"`,
+		`updateType in ["patch", "minor"]`,
+		`ecosystem != "npm"`,
+		`updates.all(u, u.type != "major")`,
+		`updates[0]["type"] == "patch"`,
+	} {
+		e, err := Compile(src)
+		if err != nil {
+			t.Errorf("Compile(%s): unexpected error: %s", src, err)
+			continue
+		}
+		if _, err := e.Eval(vars); err == nil {
+			t.Errorf("Eval(%s): expected an error because the value is unknown", src)
+		}
+	}
+
+	// Values that aren't read don't need to be known.
+	e, err := Compile(`size(updates) == 1 || updateType == "patch"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v, err := e.Eval(vars); err != nil || !v {
+		t.Errorf("got (%t, %v), expected (true, nil)", v, err)
+	}
+}
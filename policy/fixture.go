@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"os"
+
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Fixture is a saved policy input along with the decision that the policies
+// are expected to make for it.
+type Fixture struct {
+	Name string `yaml:"name"`
+	// Action is the action to evaluate the policies for ("authorize" or
+	// "merge").
+	Action string `yaml:"action"`
+	Input  Input  `yaml:"input"`
+	// Expect is the expected effect ("allow" or "deny").
+	Expect string `yaml:"expect"`
+	// Policy is the name of the policy that's expected to decide the outcome.
+	// If it's empty, any policy can.
+	Policy string `yaml:"policy"`
+}
+
+// LoadFixtures reads a YAML file containing a list of fixtures.
+func LoadFixtures(path string) ([]Fixture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening fixture file")
+	}
+	defer f.Close()
+
+	var fixtures []Fixture
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&fixtures); err != nil {
+		return nil, errors.Wrap(err, "decoding fixture file")
+	}
+
+	for i, fx := range fixtures {
+		if fx.Name == "" {
+			return nil, errors.Errorf("fixture #%d must have a name", i+1)
+		}
+		switch state.Action(fx.Action) {
+		case state.ActionAuthorize, state.ActionMerge:
+		default:
+			return nil, errors.Errorf("fixture '%s' has action '%s', but it must be '%s' or '%s'", fx.Name, fx.Action, state.ActionAuthorize, state.ActionMerge)
+		}
+		switch fx.Expect {
+		case EffectAllow, EffectDeny:
+		default:
+			return nil, errors.Errorf("fixture '%s' expects '%s', but it must be '%s' or '%s'", fx.Name, fx.Expect, EffectAllow, EffectDeny)
+		}
+	}
+
+	return fixtures, nil
+}
+
+// Test evaluates the policies against the fixture's input and returns the
+// decision and whether it's the expected one.
+func (s *Set) Test(fx Fixture) (Decision, bool, error) {
+	d, err := s.Evaluate(state.Action(fx.Action), fx.Input)
+	if err != nil {
+		return Decision{}, false, err
+	}
+	passed := d.Allowed == (fx.Expect == EffectAllow) && (fx.Policy == "" || fx.Policy == d.Policy)
+	return d, passed, nil
+}
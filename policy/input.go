@@ -0,0 +1,160 @@
+package policy
+
+import "time"
+
+// Input is the object model that policy expressions are evaluated against.
+// Each field is a variable in the expression, named after its YAML tag, so
+// fixtures for `treebot policy test` use the same names as the expressions:
+//
+//	repo == "mongodb/evergreen" && pr.age > 48h
+//	updates.all(u, u.type != "major")
+type Input struct {
+	// Repo is the full name of the PR's repo ("owner/repo").
+	Repo string `yaml:"repo"`
+	// Bot is the name of the bot that opened the PR (e.g. "dependabot").
+	Bot string `yaml:"bot"`
+	// Ecosystem is the package ecosystem of the updates, as named in the
+	// Dependabot config (e.g. "gomod" or "npm"), or empty if it's unknown.
+	// Expressions that read an unknown value fail to evaluate.
+	Ecosystem string `yaml:"ecosystem"`
+	// UpdateType is the largest type of version update in the PR ("patch",
+	// "minor" or "major"), or empty if it's unknown. Expressions that read
+	// an unknown value fail to evaluate.
+	UpdateType string `yaml:"updateType"`
+	// Updates are the dependency updates in the PR, one for each member of a
+	// grouped update.
+	Updates []Update `yaml:"updates"`
+	// PR is the pull request.
+	PR PR `yaml:"pr"`
+	// Notification is the notification that the PR was found from. It's
+	// empty if the PR was fetched directly.
+	Notification Notification `yaml:"notification"`
+	// Status is the combined state of the statuses ("success", "pending" or
+	// "failure").
+	Status string `yaml:"status"`
+	// Statuses are the latest commit statuses of the PR's head commit.
+	Statuses []Status `yaml:"statuses"`
+}
+
+// Update is a single dependency update.
+type Update struct {
+	Name string `yaml:"name"`
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// Type is "patch", "minor" or "major", or empty if it's unknown.
+	// Expressions that read an unknown value fail to evaluate.
+	Type      string `yaml:"type"`
+	Directory string `yaml:"directory"`
+}
+
+// PR describes a pull request.
+type PR struct {
+	Number int    `yaml:"number"`
+	Title  string `yaml:"title"`
+	Body   string `yaml:"body"`
+	URL    string `yaml:"url"`
+	Author string `yaml:"author"`
+	// Branch is the PR's head branch and Base is the branch that it's merged
+	// into.
+	Branch string   `yaml:"branch"`
+	Base   string   `yaml:"base"`
+	Draft  bool     `yaml:"draft"`
+	Labels []string `yaml:"labels"`
+	// Commits is the number of commits on the PR.
+	Commits int `yaml:"commits"`
+	// MergeableState is GitHub's mergeable state of the PR (e.g. "clean" or
+	// "behind").
+	MergeableState string `yaml:"mergeableState"`
+	// Age is how long ago the PR was opened, and UpdatedAge is how long ago
+	// it was last updated.
+	Age        time.Duration `yaml:"age"`
+	UpdatedAge time.Duration `yaml:"updatedAge"`
+}
+
+// Notification describes a GitHub notification.
+type Notification struct {
+	// Reason is why the notification was sent (e.g. "ci_activity").
+	Reason string `yaml:"reason"`
+	Unread bool   `yaml:"unread"`
+	// Age is how long ago the notification was last updated.
+	Age time.Duration `yaml:"age"`
+}
+
+// Status is a commit status.
+type Status struct {
+	Context     string `yaml:"context"`
+	State       string `yaml:"state"`
+	Description string `yaml:"description"`
+}
+
+// vars converts the input into the variables of an expression.
+func (in Input) vars() map[string]interface{} {
+	updates := make([]interface{}, 0, len(in.Updates))
+	for _, u := range in.Updates {
+		updates = append(updates, map[string]interface{}{
+			"name":      u.Name,
+			"from":      u.From,
+			"to":        u.To,
+			"type":      knownOr(u.Type, "the update type of '"+u.Name+"'"),
+			"directory": u.Directory,
+		})
+	}
+	statuses := make([]interface{}, 0, len(in.Statuses))
+	for _, s := range in.Statuses {
+		statuses = append(statuses, map[string]interface{}{
+			"context":     s.Context,
+			"state":       s.State,
+			"description": s.Description,
+		})
+	}
+	labels := make([]interface{}, 0, len(in.PR.Labels))
+	for _, l := range in.PR.Labels {
+		labels = append(labels, l)
+	}
+
+	return map[string]interface{}{
+		"repo":       in.Repo,
+		"bot":        in.Bot,
+		"ecosystem":  knownOr(in.Ecosystem, "the ecosystem"),
+		"updateType": knownOr(in.UpdateType, "the update type"),
+		"updates":    updates,
+		"pr": map[string]interface{}{
+			"number":         float64(in.PR.Number),
+			"title":          in.PR.Title,
+			"body":           in.PR.Body,
+			"url":            in.PR.URL,
+			"author":         in.PR.Author,
+			"branch":         in.PR.Branch,
+			"base":           in.PR.Base,
+			"draft":          in.PR.Draft,
+			"labels":         labels,
+			"commits":        float64(in.PR.Commits),
+			"mergeableState": in.PR.MergeableState,
+			"age":            in.PR.Age,
+			"updatedAge":     in.PR.UpdatedAge,
+		},
+		"notification": map[string]interface{}{
+			"reason": in.Notification.Reason,
+			"unread": in.Notification.Unread,
+			"age":    in.Notification.Age,
+		},
+		"status":   in.Status,
+		"statuses": statuses,
+	}
+}
+
+// unknown is the value of a variable or field that couldn't be determined for
+// the PR. Reading it is an error rather than an empty string so that a
+// condition such as `updateType != "major"` can't allow a PR whose update
+// type is unknown.
+type unknown struct {
+	what string
+}
+
+// knownOr returns the value, or unknown if it's empty.
+func knownOr(v, what string) interface{} {
+	if v == "" {
+		return unknown{what: what}
+	}
+	return v
+}
@@ -0,0 +1,138 @@
+package policy
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenDuration
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	// text is the token's source text, except for strings, where it's the
+	// unquoted value.
+	text string
+	pos  int
+	num  float64
+	dur  time.Duration
+}
+
+// operators are the operators and punctuation of the language, longest first
+// so that "<=" isn't lexed as "<" followed by "=".
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "-", "(", ")", "[", "]", ",", "."}
+
+// lex splits the expression into tokens.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(src) && rune(src[end]) != c {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, errors.Errorf("position %d: unterminated string", i+1)
+			}
+			raw := src[i : end+1]
+			if c == '\'' {
+				inner := strings.ReplaceAll(raw[1:len(raw)-1], `\'`, `'`)
+				raw = `"` + strings.ReplaceAll(inner, `"`, `\"`) + `"`
+			}
+			s, err := strconv.Unquote(raw)
+			if err != nil {
+				return nil, errors.Errorf("position %d: invalid string %s", i+1, src[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s, pos: i})
+			i = end + 1
+		case unicode.IsDigit(c):
+			end := i
+			for end < len(src) && (unicode.IsDigit(rune(src[end])) || src[end] == '.') {
+				end++
+			}
+			unitEnd := end
+			if unitEnd < len(src) && unicode.IsLetter(rune(src[unitEnd])) {
+				for unitEnd < len(src) && (unicode.IsLetter(rune(src[unitEnd])) || unicode.IsDigit(rune(src[unitEnd])) || src[unitEnd] == '.') {
+					unitEnd++
+				}
+			}
+			if unitEnd > end {
+				d, err := parseDuration(src[i:unitEnd])
+				if err != nil {
+					return nil, errors.Errorf("position %d: invalid duration '%s'", i+1, src[i:unitEnd])
+				}
+				tokens = append(tokens, token{kind: tokenDuration, text: src[i:unitEnd], pos: i, dur: d})
+				i = unitEnd
+				continue
+			}
+			n, err := strconv.ParseFloat(src[i:end], 64)
+			if err != nil {
+				return nil, errors.Errorf("position %d: invalid number '%s'", i+1, src[i:end])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: src[i:end], pos: i, num: n})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(src) && (unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end])) || src[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: src[i:end], pos: i})
+			i = end
+		default:
+			var op string
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.Errorf("position %d: unexpected character '%c'", i+1, c)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// parseDuration parses a Go duration, which can also be given in days (e.g.
+// "2d" or "1d12h").
+func parseDuration(s string) (time.Duration, error) {
+	var days time.Duration
+	if i := strings.Index(s, "d"); i >= 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, err
+		}
+		days = time.Duration(n) * 24 * time.Hour
+		s = s[i+1:]
+		if s == "" {
+			return days, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return days + d, nil
+}
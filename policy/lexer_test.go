@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLex(t *testing.T) {
+	for _, tc := range []struct {
+		src   string
+		kinds []tokenKind
+		texts []string
+	}{
+		{
+			src:   `pr.age >= 48h`,
+			kinds: []tokenKind{tokenIdent, tokenOp, tokenIdent, tokenOp, tokenDuration, tokenEOF},
+			texts: []string{"pr", ".", "age", ">=", "48h", ""},
+		},
+		{
+			src:   `a<=b&&!c`,
+			kinds: []tokenKind{tokenIdent, tokenOp, tokenIdent, tokenOp, tokenOp, tokenIdent, tokenEOF},
+			texts: []string{"a", "<=", "b", "&&", "!", "c", ""},
+		},
+		{
+			src:   `repo in ["a/b", 'c/d']`,
+			kinds: []tokenKind{tokenIdent, tokenIdent, tokenOp, tokenString, tokenOp, tokenString, tokenOp, tokenEOF},
+			texts: []string{"repo", "in", "[", "a/b", ",", "c/d", "]", ""},
+		},
+		{
+			src:   `size(x) == 1.5`,
+			kinds: []tokenKind{tokenIdent, tokenOp, tokenIdent, tokenOp, tokenOp, tokenNumber, tokenEOF},
+			texts: []string{"size", "(", "x", ")", "==", "1.5", ""},
+		},
+	} {
+		tokens, err := lex(tc.src)
+		if err != nil {
+			t.Errorf("lex(%q): unexpected error: %s", tc.src, err)
+			continue
+		}
+		if len(tokens) != len(tc.kinds) {
+			t.Errorf("lex(%q): got %d tokens, expected %d", tc.src, len(tokens), len(tc.kinds))
+			continue
+		}
+		for i, tok := range tokens {
+			if tok.kind != tc.kinds[i] || tok.text != tc.texts[i] {
+				t.Errorf("lex(%q): token #%d is (%d, %q), expected (%d, %q)", tc.src, i, tok.kind, tok.text, tc.kinds[i], tc.texts[i])
+			}
+		}
+	}
+}
+
+func TestLexStrings(t *testing.T) {
+	for src, expected := range map[string]string{
+		`"a\"b"`:    `a"b`,
+		`'a\'b'`:    `a'b`,
+		`'say "x"'`: `say "x"`,
+		`"tab\t"`:   "tab\t",
+		`''`:        "",
+	} {
+		tokens, err := lex(src)
+		if err != nil {
+			t.Errorf("lex(%s): unexpected error: %s", src, err)
+			continue
+		}
+		if tokens[0].kind != tokenString || tokens[0].text != expected {
+			t.Errorf("lex(%s): got %q, expected %q", src, tokens[0].text, expected)
+		}
+	}
+}
+
+func TestLexDurations(t *testing.T) {
+	for src, expected := range map[string]time.Duration{
+		"30m":    30 * time.Minute,
+		"48h":    48 * time.Hour,
+		"7d":     7 * 24 * time.Hour,
+		"1d12h":  36 * time.Hour,
+		"1h30m":  90 * time.Minute,
+		"1.5h":   90 * time.Minute,
+		"2d1h1m": 49*time.Hour + time.Minute,
+	} {
+		tokens, err := lex(src)
+		if err != nil {
+			t.Errorf("lex(%s): unexpected error: %s", src, err)
+			continue
+		}
+		if tokens[0].kind != tokenDuration || tokens[0].dur != expected {
+			t.Errorf("lex(%s): got %s, expected %s", src, tokens[0].dur, expected)
+		}
+	}
+}
+
+func TestLexErrors(t *testing.T) {
+	for _, src := range []string{
+		`"unterminated`,
+		`'unterminated`,
+		`3 # 4`,
+		`5x`,
+		`1.2.3`,
+		`a = b`,
+	} {
+		if _, err := lex(src); err == nil {
+			t.Errorf("lex(%q): expected an error", src)
+		}
+	}
+}
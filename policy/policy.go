@@ -0,0 +1,155 @@
+// Package policy evaluates user-defined rules about which PRs treebot may act
+// on. A rule's condition is an expression in a small language evaluated
+// against the PR's Input:
+//
+//	ecosystem == "gomod" && updateType != "major" && pr.age > 48h && repo in ["mongodb/a", "mongodb/b"]
+//
+// The language has boolean (&&, ||, !), comparison (==, !=, <, <=, >, >=) and
+// membership (in) operators; string, number, boolean, duration (e.g. 30m, 48h
+// or 7d) and list literals; the size() and lower() functions; the contains(),
+// startsWith(), endsWith() and matches() string methods; and the all() and
+// exists() list macros, such as updates.exists(u, u.type == "major").
+//
+// Values that couldn't be determined for a PR, such as the update type of a
+// version that isn't semver, are unknown, and an expression that reads one
+// fails to evaluate instead of comparing it as an empty string.
+package policy
+
+import (
+	"fmt"
+
+	"github.com/kimchelly/treebot-go/config"
+	"github.com/kimchelly/treebot-go/state"
+	"github.com/pkg/errors"
+)
+
+// Effects of a policy when its condition matches.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Policy is a compiled policy rule.
+type Policy struct {
+	Name   string
+	Effect string
+	// Actions are the actions that the policy applies to. If it's empty, the
+	// policy applies to every action.
+	Actions []state.Action
+	When    *Expr
+}
+
+func (p *Policy) appliesTo(action state.Action) bool {
+	if len(p.Actions) == 0 {
+		return true
+	}
+	for _, a := range p.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Set is an ordered set of policies.
+type Set struct {
+	policies []Policy
+}
+
+// NewSet compiles the policies in the config.
+func NewSet(confs []config.PolicyConfig) (*Set, error) {
+	var s Set
+	for _, c := range confs {
+		p := Policy{Name: c.Name, Effect: c.Effect}
+		switch c.Effect {
+		case EffectAllow, EffectDeny:
+		default:
+			return nil, errors.Errorf("policy '%s' has effect '%s', but it must be '%s' or '%s'", c.Name, c.Effect, EffectAllow, EffectDeny)
+		}
+		for _, a := range c.Actions {
+			switch action := state.Action(a); action {
+			case state.ActionAuthorize, state.ActionMerge:
+				p.Actions = append(p.Actions, action)
+			default:
+				return nil, errors.Errorf("policy '%s' has action '%s', but it must be '%s' or '%s'", c.Name, a, state.ActionAuthorize, state.ActionMerge)
+			}
+		}
+		when, err := Compile(c.When)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compiling condition of policy '%s'", c.Name)
+		}
+		p.When = when
+		s.policies = append(s.policies, p)
+	}
+	return &s, nil
+}
+
+// Empty returns whether there are no policies for the action.
+func (s *Set) Empty(action state.Action) bool {
+	for i := range s.policies {
+		if s.policies[i].appliesTo(action) {
+			return false
+		}
+	}
+	return true
+}
+
+// Decision is the result of evaluating the policies for an action on a PR.
+type Decision struct {
+	Allowed bool
+	// Policy is the name of the policy that decided the outcome, if any.
+	Policy string
+	// Reason describes the decision.
+	Reason string
+}
+
+// Evaluate decides whether the action is allowed on the PR described by the
+// input. The action is denied if any deny policy matches. Otherwise, it's
+// allowed if an allow policy matches or if there are no allow policies for the
+// action.
+func (s *Set) Evaluate(action state.Action, in Input) (Decision, error) {
+	vars := in.vars()
+
+	var hasAllow bool
+	var allowedBy *Policy
+	for i := range s.policies {
+		p := &s.policies[i]
+		if !p.appliesTo(action) {
+			continue
+		}
+		if p.Effect == EffectAllow {
+			hasAllow = true
+			if allowedBy != nil {
+				continue
+			}
+		}
+
+		matched, err := p.When.Eval(vars)
+		if err != nil {
+			return Decision{}, errors.Wrapf(err, "evaluating policy '%s'", p.Name)
+		}
+		if !matched {
+			continue
+		}
+		if p.Effect == EffectDeny {
+			return Decision{
+				Policy: p.Name,
+				Reason: fmt.Sprintf("policy '%s' denies it (%s)", p.Name, p.When),
+			}, nil
+		}
+		allowedBy = p
+	}
+
+	switch {
+	case allowedBy != nil:
+		return Decision{
+			Allowed: true,
+			Policy:  allowedBy.Name,
+			Reason:  fmt.Sprintf("policy '%s' allows it (%s)", allowedBy.Name, allowedBy.When),
+		}, nil
+	case hasAllow:
+		return Decision{Reason: fmt.Sprintf("none of the policies allow it to %s", action)}, nil
+	default:
+		return Decision{Allowed: true, Reason: "no policy denies it"}, nil
+	}
+}
@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kimchelly/treebot-go/config"
+	"github.com/kimchelly/treebot-go/state"
+)
+
+func TestEvaluate(t *testing.T) {
+	set, err := NewSet([]config.PolicyConfig{
+		{Name: "no-major", Effect: EffectDeny, When: `updateType == "major"`},
+		{Name: "old-gomod", Effect: EffectAllow, Actions: []string{"merge"}, When: `ecosystem == "gomod" && pr.age > 48h`},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		action  state.Action
+		in      Input
+		allowed bool
+		policy  string
+	}{
+		{
+			name:    "DenyWins",
+			action:  state.ActionMerge,
+			in:      Input{Ecosystem: "gomod", UpdateType: "major", PR: PR{Age: 72 * time.Hour}},
+			allowed: false,
+			policy:  "no-major",
+		},
+		{
+			name:    "AllowMatches",
+			action:  state.ActionMerge,
+			in:      Input{Ecosystem: "gomod", UpdateType: "minor", PR: PR{Age: 72 * time.Hour}},
+			allowed: true,
+			policy:  "old-gomod",
+		},
+		{
+			name:    "NoAllowMatches",
+			action:  state.ActionMerge,
+			in:      Input{Ecosystem: "npm", UpdateType: "minor", PR: PR{Age: 72 * time.Hour}},
+			allowed: false,
+		},
+		{
+			name:    "NoAllowPoliciesForAction",
+			action:  state.ActionAuthorize,
+			in:      Input{Ecosystem: "npm", UpdateType: "patch"},
+			allowed: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := set.Evaluate(tc.action, tc.in)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if d.Allowed != tc.allowed || d.Policy != tc.policy {
+				t.Errorf("got (%t, '%s'), expected (%t, '%s'): %s", d.Allowed, d.Policy, tc.allowed, tc.policy, d.Reason)
+			}
+		})
+	}
+
+	t.Run("UnknownUpdateType", func(t *testing.T) {
+		if _, err := set.Evaluate(state.ActionAuthorize, Input{Ecosystem: "gomod"}); err == nil {
+			t.Error("expected an error because the update type is unknown")
+		}
+	})
+}
+
+func TestNewSetErrors(t *testing.T) {
+	for name, conf := range map[string]config.PolicyConfig{
+		"BadEffect":  {Name: "p", Effect: "maybe", When: `true`},
+		"BadAction":  {Name: "p", Effect: EffectAllow, Actions: []string{"close"}, When: `true`},
+		"BadWhen":    {Name: "p", Effect: EffectAllow, When: `true &&`},
+		"BadPattern": {Name: "p", Effect: EffectAllow, When: `repo.matches("(")`},
+	} {
+		if _, err := NewSet([]config.PolicyConfig{conf}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,674 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Action is an action that treebot takes on a PR.
+type Action string
+
+const (
+	ActionAuthorize    Action = "authorize"
+	ActionMerge        Action = "merge"
+	ActionRevert       Action = "revert"
+	ActionCombine      Action = "combine"
+	ActionRebase       Action = "rebase"
+	ActionUpdateBranch Action = "update-branch"
+)
+
+// maxHistory is the maximum number of events kept for a single PR.
+const maxHistory = 50
+
+// maxPRRecordAge is how long treebot remembers a PR after its record was last
+// changed.
+const maxPRRecordAge = 30 * 24 * time.Hour
+
+// Event is a single action that treebot attempted on a PR.
+type Event struct {
+	Time    time.Time `json:"time"`
+	HeadSHA string    `json:"head_sha"`
+	Action  Action    `json:"action"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// PRRecord is everything treebot remembers about a PR between runs.
+type PRRecord struct {
+	Repo   string `json:"repo"`
+	Number int    `json:"number"`
+	// HeadSHA is the most recently seen head commit of the PR.
+	HeadSHA   string    `json:"head_sha"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// UpdatedAt is when the record was last changed.
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	// LastResult is the result of the most recent check of the PR.
+	LastResult string `json:"last_result,omitempty"`
+	// Completed is when each action was successfully completed for HeadSHA.
+	Completed map[Action]time.Time `json:"completed,omitempty"`
+	// Failures is the number of failed attempts of each action for HeadSHA.
+	Failures map[Action]int `json:"failures,omitempty"`
+	// History is the most recent attempted actions on the PR, oldest first.
+	History []Event `json:"history,omitempty"`
+
+	// Hold is set if a user asked treebot not to act on the PR.
+	Hold *Hold `json:"hold,omitempty"`
+	// Forced is the actions that a user asked treebot to take regardless of
+	// its usual checks. A forced action is cleared once it's completed or the
+	// PR's head commit changes.
+	Forced map[Action]ForcedAction `json:"forced,omitempty"`
+	// Blocked is what blocks each action on the PR, as explained in treebot's
+	// comment on the PR.
+	Blocked map[Action]string `json:"blocked,omitempty"`
+	// ExplainRequestedBy is the user who asked treebot to explain its
+	// decision about the PR, if any.
+	ExplainRequestedBy string `json:"explain_requested_by,omitempty"`
+	// HandledComments are the IDs of PR comments whose commands treebot has
+	// already handled.
+	HandledComments []int64 `json:"handled_comments,omitempty"`
+	// Rebase is set if treebot asked Dependabot to resolve the PR's merge
+	// conflicts and the PR hasn't been mergeable since.
+	Rebase *RebaseRequest `json:"rebase,omitempty"`
+	// BranchUpdate is set if treebot updated the PR's branch with the changes
+	// from its base branch.
+	BranchUpdate *BranchUpdate `json:"branch_update,omitempty"`
+}
+
+// Hold describes a user's request for treebot not to act on a PR.
+type Hold struct {
+	By string    `json:"by"`
+	At time.Time `json:"at"`
+}
+
+// ForcedAction describes a user's request for treebot to take an action on a
+// PR regardless of its usual checks.
+type ForcedAction struct {
+	By string `json:"by"`
+	// HeadSHA is the head commit of the PR when the user asked. The request
+	// doesn't apply to any other commit.
+	HeadSHA string    `json:"head_sha"`
+	At      time.Time `json:"at"`
+}
+
+// UnmarshalJSON also accepts the old format of a forced action, which was
+// just the user who asked. Since the commit that the user approved is
+// unknown, the old format never applies to any commit.
+func (f *ForcedAction) UnmarshalJSON(b []byte) error {
+	var by string
+	if err := json.Unmarshal(b, &by); err == nil {
+		*f = ForcedAction{By: by}
+		return nil
+	}
+	type forcedAction ForcedAction
+	return json.Unmarshal(b, (*forcedAction)(f))
+}
+
+// ForcedBy returns the user who forced the action on the given head commit,
+// if any.
+func (r *PRRecord) ForcedBy(action Action, headSHA string) string {
+	f, ok := r.Forced[action]
+	if !ok || f.HeadSHA == "" || f.HeadSHA != headSHA {
+		return ""
+	}
+	return f.By
+}
+
+// RebaseRequest describes treebot's most recent request for Dependabot to
+// resolve a PR's merge conflicts.
+type RebaseRequest struct {
+	// Command is the Dependabot command that treebot posted.
+	Command string `json:"command"`
+	// HeadSHA is the head commit of the PR when the command was posted.
+	HeadSHA string    `json:"head_sha"`
+	At      time.Time `json:"at"`
+	// Requests is the number of times treebot has asked Dependabot to resolve
+	// the conflicts since the PR was last mergeable.
+	Requests int `json:"requests"`
+}
+
+// BranchUpdate describes treebot's most recent update of a PR's branch with
+// the changes from its base branch.
+type BranchUpdate struct {
+	// Method is how the branch was updated.
+	Method string `json:"method"`
+	// FromSHA is the head commit of the PR before the update.
+	FromSHA string    `json:"from_sha"`
+	At      time.Time `json:"at"`
+}
+
+// IsCommentHandled returns whether the commands in the PR comment were already
+// handled.
+func (r *PRRecord) IsCommentHandled(id int64) bool {
+	for _, handled := range r.HandledComments {
+		if handled == id {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCompleted returns whether the action was already completed for the given
+// head commit.
+func (r *PRRecord) IsCompleted(action Action, headSHA string) bool {
+	if r.HeadSHA != headSHA {
+		return false
+	}
+	_, ok := r.Completed[action]
+	return ok
+}
+
+// FailuresFor returns the number of failed attempts of the action for the
+// given head commit.
+func (r *PRRecord) FailuresFor(action Action, headSHA string) int {
+	if r.HeadSHA != headSHA {
+		return 0
+	}
+	return r.Failures[action]
+}
+
+// maxRepoHistory is how long treebot remembers actions in a repo.
+const maxRepoHistory = 7 * 24 * time.Hour
+
+// RepoRecord is everything treebot remembers about a repo between runs.
+type RepoRecord struct {
+	// MergedCommits are the commits that treebot created by merging PRs in the
+	// repo, oldest first.
+	MergedCommits []MergedCommit `json:"merged_commits,omitempty"`
+	// HeldDependencies are the dependencies whose updates treebot won't act
+	// on, mapped to why they're held.
+	HeldDependencies map[string]DependencyHold `json:"held_dependencies,omitempty"`
+	// CombinedPRs are the PRs that treebot opened to combine other PRs and
+	// that haven't been resolved yet.
+	CombinedPRs []CombinedPR `json:"combined_prs,omitempty"`
+	// LegacyMerges are the times when treebot merged PRs in the repo, oldest
+	// first, from before merged commits were recorded. They still count
+	// towards the merge limits until they're older than the repo history.
+	LegacyMerges []time.Time `json:"merges,omitempty"`
+}
+
+// Verification states of a merged commit.
+const (
+	// VerificationPending means that the commit's mainline build hasn't
+	// finished yet.
+	VerificationPending = ""
+	VerificationPassed  = "passed"
+	VerificationFailed  = "failed"
+	// VerificationPreexisting means that the commit's mainline build failed,
+	// but the build of the commit before it was already failing.
+	VerificationPreexisting = "preexisting-failure"
+	// VerificationNoStatuses means that no statuses were reported on the
+	// commit before treebot gave up waiting for them.
+	VerificationNoStatuses = "no-statuses"
+	// VerificationInconclusive means that the commit's mainline build failed,
+	// but there was no finished build of an earlier commit to tell whether
+	// the commit broke it.
+	VerificationInconclusive = "inconclusive"
+)
+
+// MergedCommit is a commit that treebot created on a repo's branch by merging
+// a PR.
+type MergedCommit struct {
+	SHA      string    `json:"sha"`
+	Branch   string    `json:"branch"`
+	PRNumber int       `json:"pr_number"`
+	Title    string    `json:"title"`
+	MergedAt time.Time `json:"merged_at"`
+	// Dependencies are the names of the dependencies that the PR updated.
+	Dependencies []string `json:"dependencies,omitempty"`
+	// Verification is the result of checking the commit's mainline build.
+	Verification string `json:"verification,omitempty"`
+	// RevertPR is the number of the PR that treebot opened to revert the
+	// commit, if any.
+	RevertPR int `json:"revert_pr,omitempty"`
+}
+
+// DependencyHold describes why treebot won't act on updates of a dependency.
+type DependencyHold struct {
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// CombinedPR is a PR that treebot opened to combine the changes of other PRs.
+type CombinedPR struct {
+	Number int    `json:"number"`
+	Branch string `json:"branch"`
+	// Includes are the numbers of the PRs whose changes are combined.
+	Includes  []int     `json:"includes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// storeData is the on-disk format of the store.
+type storeData struct {
+	PRs   map[string]*PRRecord   `json:"prs"`
+	Repos map[string]*RepoRecord `json:"repos,omitempty"`
+}
+
+func newStoreData() storeData {
+	return storeData{
+		PRs:   map[string]*PRRecord{},
+		Repos: map[string]*RepoRecord{},
+	}
+}
+
+// prune forgets the PRs whose records haven't changed in a while, since they
+// were most likely closed. Records from before UpdatedAt was recorded are
+// pruned based on when the PR was last seen.
+func (d *storeData) prune(now time.Time) {
+	cutoff := now.Add(-maxPRRecordAge)
+	for key, r := range d.PRs {
+		updated := r.UpdatedAt
+		if updated.IsZero() {
+			updated = r.LastSeen
+		}
+		if updated.Before(cutoff) {
+			delete(d.PRs, key)
+		}
+	}
+}
+
+// Store is a file-based key-value store for state that needs to persist
+// between runs. Every change is written to disk immediately. It is safe for
+// concurrent use, including by several processes using the same file: each
+// change is applied to the latest state on disk while holding a lock on the
+// file, so changes made by other processes aren't lost.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	data storeData
+}
+
+// Open loads the store from the file at the given path, creating it if it
+// doesn't exist. If the path is empty, the store is only kept in memory.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: newStoreData()}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	s.data = data
+
+	return s, nil
+}
+
+// load reads the state from the file at the given path. If the file doesn't
+// exist, the state is empty.
+func load(path string) (storeData, error) {
+	data := newStoreData()
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return data, nil
+	}
+	if err != nil {
+		return storeData{}, errors.Wrap(err, "reading state file")
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return storeData{}, errors.Wrap(err, "decoding state file")
+	}
+	if data.PRs == nil {
+		data.PRs = map[string]*PRRecord{}
+	}
+	if data.Repos == nil {
+		data.Repos = map[string]*RepoRecord{}
+	}
+	return data, nil
+}
+
+func prKey(repo string, number int) string {
+	return fmt.Sprintf("%s#%d", repo, number)
+}
+
+// PR returns the record for the PR. If there is no record, it returns an empty
+// record for the PR.
+func (s *Store) PR(repo string, number int) PRRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.data.PRs[prKey(repo, number)]
+	if !ok {
+		return PRRecord{Repo: repo, Number: number}
+	}
+	return copyRecord(r)
+}
+
+// ObservePR records that the PR was seen with the given head commit. If the
+// head commit changed, the completed actions, failure counts and forced
+// actions are reset.
+func (s *Store) ObservePR(repo string, number int, headSHA string) error {
+	return s.updatePR(repo, number, func(r *PRRecord) {
+		now := time.Now()
+		if r.FirstSeen.IsZero() {
+			r.FirstSeen = now
+		}
+		r.LastSeen = now
+		if r.HeadSHA != headSHA {
+			r.HeadSHA = headSHA
+			r.Completed = nil
+			r.Failures = nil
+			r.Forced = nil
+		}
+	})
+}
+
+// RecordResult records the result of checking the PR.
+func (s *Store) RecordResult(repo string, number int, result string) error {
+	return s.updatePR(repo, number, func(r *PRRecord) {
+		r.LastResult = result
+	})
+}
+
+// MergesSince returns the number of PRs that treebot merged in the repo since
+// the given time.
+func (s *Store) MergesSince(repo string, since time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.data.Repos[repo]
+	if !ok {
+		return 0
+	}
+	var count int
+	for _, mc := range r.MergedCommits {
+		if !mc.MergedAt.Before(since) {
+			count++
+		}
+	}
+	for _, t := range r.LegacyMerges {
+		if !t.Before(since) {
+			count++
+		}
+	}
+	return count
+}
+
+// RecordMergedCommit remembers a commit that treebot created by merging a PR.
+// Commits are forgotten once they're older than the repo history.
+func (s *Store) RecordMergedCommit(repo string, mc MergedCommit) error {
+	return s.updateRepo(repo, func(r *RepoRecord) {
+		r.MergedCommits = append(r.MergedCommits, mc)
+
+		cutoff := time.Now().Add(-maxRepoHistory)
+		for len(r.MergedCommits) > 0 && r.MergedCommits[0].MergedAt.Before(cutoff) {
+			r.MergedCommits = r.MergedCommits[1:]
+		}
+		for len(r.LegacyMerges) > 0 && r.LegacyMerges[0].Before(cutoff) {
+			r.LegacyMerges = r.LegacyMerges[1:]
+		}
+	})
+}
+
+// UnverifiedCommits returns the merged commits in each repo whose mainline
+// build hasn't been verified yet, oldest first.
+func (s *Store) UnverifiedCommits() map[string][]MergedCommit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unverified := map[string][]MergedCommit{}
+	for repo, r := range s.data.Repos {
+		for _, mc := range r.MergedCommits {
+			if mc.Verification == VerificationPending {
+				unverified[repo] = append(unverified[repo], mc)
+			}
+		}
+	}
+	return unverified
+}
+
+// UpdateMergedCommit applies the update to the merged commit with the given
+// SHA in the repo and saves it.
+func (s *Store) UpdateMergedCommit(repo, sha string, update func(mc *MergedCommit)) error {
+	return s.updateRepo(repo, func(r *RepoRecord) {
+		for i := range r.MergedCommits {
+			if r.MergedCommits[i].SHA == sha {
+				update(&r.MergedCommits[i])
+			}
+		}
+	})
+}
+
+// HoldDependency stops treebot from acting on updates of the dependency in
+// the repo until the given time.
+func (s *Store) HoldDependency(repo, dependency string, hold DependencyHold) error {
+	return s.updateRepo(repo, func(r *RepoRecord) {
+		if r.HeldDependencies == nil {
+			r.HeldDependencies = map[string]DependencyHold{}
+		}
+		r.HeldDependencies[dependency] = hold
+	})
+}
+
+// DependencyHeld returns the hold on the dependency in the repo, if there is
+// one that hasn't expired.
+func (s *Store) DependencyHeld(repo, dependency string, now time.Time) (DependencyHold, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.data.Repos[repo]
+	if !ok {
+		return DependencyHold{}, false
+	}
+	hold, ok := r.HeldDependencies[dependency]
+	if !ok || !now.Before(hold.Until) {
+		return DependencyHold{}, false
+	}
+	return hold, true
+}
+
+// RecordAction records an attempt of the action on the PR's head commit. If
+// actionErr is nil, the action is considered completed; otherwise, it counts as
+// a failed attempt.
+func (s *Store) RecordAction(repo string, number int, headSHA string, action Action, actionErr error) error {
+	return s.updatePR(repo, number, func(r *PRRecord) {
+		now := time.Now()
+		if r.HeadSHA != headSHA {
+			r.HeadSHA = headSHA
+			r.Completed = nil
+			r.Failures = nil
+			r.Forced = nil
+		}
+
+		e := Event{
+			Time:    now,
+			HeadSHA: headSHA,
+			Action:  action,
+		}
+		if actionErr != nil {
+			e.Error = actionErr.Error()
+			if r.Failures == nil {
+				r.Failures = map[Action]int{}
+			}
+			r.Failures[action]++
+		} else {
+			if r.Completed == nil {
+				r.Completed = map[Action]time.Time{}
+			}
+			r.Completed[action] = now
+			delete(r.Forced, action)
+		}
+
+		r.History = append(r.History, e)
+		if len(r.History) > maxHistory {
+			r.History = r.History[len(r.History)-maxHistory:]
+		}
+	})
+}
+
+// RecordCombinedPR remembers a PR that treebot opened to combine other PRs.
+func (s *Store) RecordCombinedPR(repo string, cp CombinedPR) error {
+	return s.updateRepo(repo, func(r *RepoRecord) {
+		r.CombinedPRs = append(r.CombinedPRs, cp)
+	})
+}
+
+// CombinedPRs returns the unresolved combined PRs in each repo.
+func (s *Store) CombinedPRs() map[string][]CombinedPR {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	combined := map[string][]CombinedPR{}
+	for repo, r := range s.data.Repos {
+		for _, cp := range r.CombinedPRs {
+			cp.Includes = append([]int(nil), cp.Includes...)
+			combined[repo] = append(combined[repo], cp)
+		}
+	}
+	return combined
+}
+
+// ForgetCombinedPR forgets the combined PR once it's resolved.
+func (s *Store) ForgetCombinedPR(repo string, number int) error {
+	return s.updateRepo(repo, func(r *RepoRecord) {
+		var remaining []CombinedPR
+		for _, cp := range r.CombinedPRs {
+			if cp.Number != number {
+				remaining = append(remaining, cp)
+			}
+		}
+		r.CombinedPRs = remaining
+	})
+}
+
+// UpdatePR applies the update to the PR's record and saves it.
+func (s *Store) UpdatePR(repo string, number int, update func(r *PRRecord)) error {
+	return s.updatePR(repo, number, update)
+}
+
+func (s *Store) updatePR(repo string, number int, update func(r *PRRecord)) error {
+	return s.update(func(d *storeData) {
+		key := prKey(repo, number)
+		r, ok := d.PRs[key]
+		if !ok {
+			r = &PRRecord{Repo: repo, Number: number}
+			d.PRs[key] = r
+		}
+		update(r)
+		r.UpdatedAt = time.Now()
+	})
+}
+
+func (s *Store) updateRepo(repo string, update func(r *RepoRecord)) error {
+	return s.update(func(d *storeData) {
+		r, ok := d.Repos[repo]
+		if !ok {
+			r = &RepoRecord{}
+			d.Repos[repo] = r
+		}
+		update(r)
+	})
+}
+
+// update applies the change to the state and saves it. The state file is
+// locked and reloaded first so that the change is applied on top of the
+// changes that other processes saved since the store was loaded.
+func (s *Store) update(change func(d *storeData)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		change(&s.data)
+		s.data.prune(time.Now())
+		return nil
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "creating state directory")
+	}
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return errors.Wrap(err, "locking state file")
+	}
+	defer unlock()
+
+	data, err := load(s.path)
+	if err != nil {
+		return errors.Wrap(err, "reloading state file")
+	}
+	change(&data)
+	data.prune(time.Now())
+	s.data = data
+
+	return s.save()
+}
+
+// save writes the store to disk. Callers must hold both the store's lock and
+// the state file's lock.
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding state")
+	}
+
+	dir := filepath.Dir(s.path)
+
+	// Write to a temporary file first so that the state file is never left
+	// partially written.
+	f, err := ioutil.TempFile(dir, filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary state file")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return errors.Wrap(err, "writing temporary state file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "closing temporary state file")
+	}
+
+	return errors.Wrap(os.Rename(f.Name(), s.path), "moving state file into place")
+}
+
+func copyRecord(r *PRRecord) PRRecord {
+	cp := *r
+	if r.Completed != nil {
+		cp.Completed = make(map[Action]time.Time, len(r.Completed))
+		for k, v := range r.Completed {
+			cp.Completed[k] = v
+		}
+	}
+	if r.Failures != nil {
+		cp.Failures = make(map[Action]int, len(r.Failures))
+		for k, v := range r.Failures {
+			cp.Failures[k] = v
+		}
+	}
+	cp.History = append([]Event(nil), r.History...)
+	if r.Hold != nil {
+		hold := *r.Hold
+		cp.Hold = &hold
+	}
+	if r.Forced != nil {
+		cp.Forced = make(map[Action]ForcedAction, len(r.Forced))
+		for k, v := range r.Forced {
+			cp.Forced[k] = v
+		}
+	}
+	if r.Blocked != nil {
+		cp.Blocked = make(map[Action]string, len(r.Blocked))
+		for k, v := range r.Blocked {
+			cp.Blocked[k] = v
+		}
+	}
+	cp.HandledComments = append([]int64(nil), r.HandledComments...)
+	if r.Rebase != nil {
+		rebase := *r.Rebase
+		cp.Rebase = &rebase
+	}
+	if r.BranchUpdate != nil {
+		update := *r.BranchUpdate
+		cp.BranchUpdate = &update
+	}
+	return cp
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreKeepsChangesFromOtherStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	a, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := a.ObservePR("owner/repo", 1, "sha1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := b.ObservePR("owner/repo", 2, "sha2"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := a.RecordResult("owner/repo", 2, "done"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if r := reopened.PR("owner/repo", 1); r.HeadSHA != "sha1" {
+		t.Errorf("PR #1: got head SHA '%s', expected 'sha1'", r.HeadSHA)
+	}
+	if r := reopened.PR("owner/repo", 2); r.HeadSHA != "sha2" || r.LastResult != "done" {
+		t.Errorf("PR #2: got head SHA '%s' and result '%s', expected 'sha2' and 'done'", r.HeadSHA, r.LastResult)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	d := newStoreData()
+	d.PRs["recent"] = &PRRecord{UpdatedAt: now.Add(-time.Hour)}
+	d.PRs["old"] = &PRRecord{UpdatedAt: now.Add(-maxPRRecordAge - time.Hour)}
+	d.PRs["legacy-recent"] = &PRRecord{LastSeen: now.Add(-time.Hour)}
+	d.PRs["legacy-old"] = &PRRecord{LastSeen: now.Add(-maxPRRecordAge - time.Hour)}
+
+	d.prune(now)
+
+	for _, key := range []string{"recent", "legacy-recent"} {
+		if _, ok := d.PRs[key]; !ok {
+			t.Errorf("expected '%s' to be kept", key)
+		}
+	}
+	for _, key := range []string{"old", "legacy-old"} {
+		if _, ok := d.PRs[key]; ok {
+			t.Errorf("expected '%s' to be pruned", key)
+		}
+	}
+}
+
+func TestMergesSinceCountsLegacyMerges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now().UTC()
+	legacy := `{"prs": {}, "repos": {"owner/repo": {"merges": ["` + now.Add(-48*time.Hour).Format(time.RFC3339) + `", "` + now.Add(-time.Hour).Format(time.RFC3339) + `"]}}}`
+	if err := ioutil.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.RecordMergedCommit("owner/repo", MergedCommit{SHA: "abc", MergedAt: now}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count := s.MergesSince("owner/repo", now.Add(-24*time.Hour)); count != 2 {
+		t.Errorf("got %d merges in the last day, expected 2", count)
+	}
+	if count := s.MergesSince("owner/repo", now.Add(-72*time.Hour)); count != 3 {
+		t.Errorf("got %d merges in the last 3 days, expected 3", count)
+	}
+}